@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Access modes checked by the ACLs
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// ACL holds glob patterns of the form schema, schema.table or, in deny lists
+// only, schema.table.column. Deny wins; an empty allow list allows every
+// relation that is not denied.
+type ACL struct {
+	Allow []string
+	Deny  []string
+}
+
+var (
+	ReadACL  ACL
+	WriteACL ACL
+	// AllowedFunctions are patterns of restrictedFunctions that may be called
+	// even though read rules are set
+	AllowedFunctions []string
+)
+
+// restrictedFunctions read tables named by an argument, run SQL given as
+// text or read server files, so the relations they touch cannot be checked.
+// While read rules are set they are refused unless in AllowedFunctions.
+var restrictedFunctions = []string{
+	"table_to_xml*", "query_to_xml*", "cursor_to_xml*", "schema_to_xml*", "database_to_xml*",
+	"ts_stat", "dblink*", "lo_import", "lo_export", "lo_get", "lo_open",
+	"pg_read_file", "pg_read_binary_file", "pg_ls_dir", "pg_stat_file",
+}
+
+// statisticsViews expose values sampled from every table, so they are not
+// readable while read rules are set
+var statisticsViews = []string{"pg_stats", "pg_stats_ext", "pg_stats_ext_exprs", "pg_statistic", "pg_statistic_ext_data"}
+
+// ParseACLPatterns validates a comma separated list of ACL patterns
+func ParseACLPatterns(value string, allow bool) ([]string, error) {
+	patterns, err := ParsePatternList(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range patterns {
+		segments := strings.Split(pattern, ".")
+		if len(segments) > 3 {
+			return nil, fmt.Errorf("invalid pattern %q (use schema, schema.table or schema.table.column)", pattern)
+		}
+		if allow && len(segments) == 3 {
+			return nil, fmt.Errorf("column pattern %q is only supported in deny lists", pattern)
+		}
+	}
+	return patterns, nil
+}
+
+func aclFor(mode string) *ACL {
+	if mode == AccessWrite {
+		return &WriteACL
+	}
+	return &ReadACL
+}
+
+// ACLEnabled reports whether any ACL rule is configured
+func ACLEnabled() bool {
+	return len(ReadACL.Allow)+len(ReadACL.Deny)+len(WriteACL.Allow)+len(WriteACL.Deny) > 0
+}
+
+// readRulesSet reports whether the read ACL holds any rule
+func readRulesSet() bool {
+	return len(ReadACL.Allow)+len(ReadACL.Deny) > 0
+}
+
+// FunctionAllowed reports whether the function called name may be called
+func FunctionAllowed(name string) bool {
+	if !readRulesSet() {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range AllowedFunctions {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return !restrictedFunction(name)
+}
+
+// restrictedFunction reports whether name is one of restrictedFunctions
+func restrictedFunction(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range restrictedFunctions {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TableAllowed reports whether schema.table may be accessed in mode. The
+// system catalogs stay readable unless denied explicitly, except for the
+// statisticsViews.
+func TableAllowed(mode, schema, table string) bool {
+	if mode == AccessRead && schema == "pg_catalog" && readRulesSet() && slices.Contains(statisticsViews, table) {
+		return false
+	}
+	acl := aclFor(mode)
+	for _, pattern := range acl.Deny {
+		if segments := strings.Split(pattern, "."); len(segments) <= 2 && matchSegments(segments, schema, table) {
+			return false
+		}
+	}
+	if len(acl.Allow) == 0 {
+		return true
+	}
+	if mode == AccessRead && (schema == "pg_catalog" || schema == "information_schema") {
+		return true
+	}
+	for _, pattern := range acl.Allow {
+		if matchSegments(strings.Split(pattern, "."), schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// ColumnAllowed reports whether schema.table.column may be accessed in mode
+func ColumnAllowed(mode, schema, table, column string) bool {
+	for _, pattern := range aclFor(mode).Deny {
+		if segments := strings.Split(pattern, "."); len(segments) == 3 && matchSegments(segments, schema, table, column) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasColumnRules reports whether some columns of schema.table are denied in mode
+func hasColumnRules(mode, schema, table string) bool {
+	for _, pattern := range aclFor(mode).Deny {
+		if segments := strings.Split(pattern, "."); len(segments) == 3 && matchSegments(segments[:2], schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(segments []string, values ...string) bool {
+	for i, segment := range segments {
+		if ok, _ := path.Match(segment, values[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckTableAccess rejects a schema tool call on a table the ACLs deny
+func CheckTableAccess(mode, schema, table string) error {
+	if TableAllowed(mode, schema, table) {
+		return nil
+	}
+	return Errorf("err_table_access_denied", "access denied: %s of table %s.%s is not permitted", accessNoun(mode), schema, table)
+}
+
+// FilterTableRows drops rows describing tables the read ACL hides
+func FilterTableRows(rows []map[string]interface{}, schemaColumn, tableColumn string) []map[string]interface{} {
+	if !ACLEnabled() {
+		return rows
+	}
+	visible := rows[:0]
+	for _, row := range rows {
+		schema, _ := row[schemaColumn].(string)
+		table, _ := row[tableColumn].(string)
+		if TableAllowed(AccessRead, schema, table) {
+			visible = append(visible, row)
+		}
+	}
+	return visible
+}
+
+func accessNoun(mode string) string {
+	if mode == AccessWrite {
+		return T("access_writing", "writing")
+	}
+	return T("access_reading", "reading")
+}
+
+// aclTable is a relation referenced by a query
+type aclTable struct {
+	name   string // As written in the query, schema.table or table
+	schema string
+	table  string
+	mode   string
+}
+
+// CheckAccess enforces the ACLs on every table and column a query references.
+// Tables modified by DML or DDL need write access, all others read access.
+// Column rules are applied conservatively: a column name is checked against
+// every table it could belong to, and * or whole-row references are rejected
+// on tables with restricted columns.
+func CheckAccess(ctx context.Context, query string) error {
+	if !ACLEnabled() {
+		return nil
+	}
+
+	summary, err := pg_query.Summary(query, -1)
+	if err != nil {
+		return Errorf("err_parse_query", "failed to parse query: %v", err)
+	}
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return Errorf("err_parse_query", "failed to parse query: %v", err)
+	}
+
+	var tables []*aclTable
+	for _, t := range summary.Tables {
+		mode := AccessRead
+		if t.Context == pg_query.SummaryResult_DML || t.Context == pg_query.SummaryResult_DDL {
+			mode = AccessWrite
+		}
+		tables = append(tables, &aclTable{name: t.Name, schema: t.SchemaName, table: t.TableName, mode: mode})
+	}
+	// Comments name their table outside of any relation the summary lists
+	for _, raw := range tree.Stmts {
+		if comment := raw.Stmt.GetCommentStmt(); comment != nil {
+			if schema, table, _, ok := CommentTarget(comment); ok {
+				tables = append(tables, &aclTable{name: qualifiedName(schema, table), schema: schema, table: table, mode: AccessWrite})
+			}
+		}
+	}
+	if tables, err = resolveSchemas(ctx, tables); err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		if !TableAllowed(t.mode, t.schema, t.table) {
+			return Errorf("err_table_access_denied", "access denied: %s of table %s.%s is not permitted", accessNoun(t.mode), t.schema, t.table)
+		}
+	}
+
+	checker := &accessChecker{tables: tables, aliases: summary.Aliases}
+	for _, raw := range tree.Stmts {
+		var err error
+		walkNodes(raw.Stmt.ProtoReflect(), func(m protoreflect.ProtoMessage) bool {
+			switch node := m.(type) {
+			case *pg_query.ColumnRef:
+				err = checker.columnRef(node)
+			case *pg_query.A_Indirection:
+				err = checker.indirection(node)
+			case *pg_query.FuncCall:
+				err = checkFunction(node)
+			case *pg_query.JoinExpr:
+				err = checker.join(node)
+			case *pg_query.InsertStmt:
+				err = checker.insertColumns(node)
+			case *pg_query.UpdateStmt:
+				targets := checker.lookup(rangeVarName(node.Relation))
+				for _, target := range node.TargetList {
+					if rt := target.GetResTarget(); rt != nil && err == nil {
+						err = checker.column(AccessWrite, rt.Name, targets)
+					}
+				}
+			case *pg_query.CommentStmt:
+				if schema, table, column, ok := CommentTarget(node); ok && column != "" {
+					err = checker.column(AccessWrite, column, checker.lookup(qualifiedName(schema, table)))
+				}
+			case *pg_query.AlterTableStmt:
+				targets := checker.lookup(rangeVarName(node.Relation))
+				for _, cmd := range node.Cmds {
+					if c := cmd.GetAlterTableCmd(); c != nil && c.Name != "" && err == nil {
+						err = checker.column(AccessWrite, c.Name, targets)
+					}
+				}
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accessChecker applies the column rules to the tables of one query
+type accessChecker struct {
+	tables  []*aclTable
+	aliases map[string]string
+}
+
+// lookup returns the tables a qualifier such as an alias may stand for
+func (c *accessChecker) lookup(name string) []*aclTable {
+	if target, ok := c.aliases[name]; ok {
+		name = target
+	}
+	var matches []*aclTable
+	for _, t := range c.tables {
+		if t.name == name || t.table == name || t.schema+"."+t.table == name {
+			matches = append(matches, t)
+		}
+	}
+	if len(matches) == 0 {
+		return c.tables // Subquery or CTE: could be any of them
+	}
+	return matches
+}
+
+// isRelation reports whether name is an alias or table of the query
+func (c *accessChecker) isRelation(name string) bool {
+	if _, ok := c.aliases[name]; ok {
+		return true
+	}
+	for _, t := range c.tables {
+		if t.table == name || t.name == name || t.schema+"."+t.table == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *accessChecker) column(mode, column string, candidates []*aclTable) error {
+	for _, t := range candidates {
+		if !ColumnAllowed(mode, t.schema, t.table, column) {
+			return Errorf("err_column_access_denied", "access denied: %s of column %s.%s.%s is not permitted", accessNoun(mode), t.schema, t.table, column)
+		}
+	}
+	return nil
+}
+
+func (c *accessChecker) star(candidates []*aclTable) error {
+	for _, t := range candidates {
+		if hasColumnRules(AccessRead, t.schema, t.table) {
+			return Errorf("err_restricted_star", "access denied: %s.%s has restricted columns, list the columns to read instead of using *", t.schema, t.table)
+		}
+	}
+	return nil
+}
+
+func (c *accessChecker) columnRef(ref *pg_query.ColumnRef) error {
+	var names []string
+	star := false
+	for _, field := range ref.Fields {
+		if s := field.GetString_(); s != nil {
+			names = append(names, s.Sval)
+		} else if field.GetAStar() != nil {
+			star = true
+		}
+	}
+
+	switch {
+	case star && len(names) == 0:
+		return c.star(c.tables)
+	case star:
+		return c.star(c.lookup(strings.Join(names, ".")))
+	case len(names) == 1:
+		// A bare table name or alias is a whole-row reference
+		if c.isRelation(names[0]) {
+			if err := c.star(c.lookup(names[0])); err != nil {
+				return err
+			}
+		}
+		return c.column(AccessRead, names[0], c.tables)
+	default:
+		// So is a schema qualified table name
+		if name := strings.Join(names, "."); c.isRelation(name) {
+			if err := c.star(c.lookup(name)); err != nil {
+				return err
+			}
+		}
+		qualifier := strings.Join(names[:len(names)-1], ".")
+		return c.column(AccessRead, names[len(names)-1], c.lookup(qualifier))
+	}
+}
+
+// indirection checks the fields selected from a value, e.g. (users).ssn, as
+// columns of the relation a whole-row reference names, or of every table
+func (c *accessChecker) indirection(ind *pg_query.A_Indirection) error {
+	candidates := c.tables
+	if ref := ind.Arg.GetColumnRef(); ref != nil {
+		var names []string
+		for _, field := range ref.Fields {
+			names = append(names, field.GetString_().GetSval())
+		}
+		if name := strings.Join(names, "."); c.isRelation(name) {
+			candidates = c.lookup(name)
+		}
+	}
+	for _, field := range ind.Indirection {
+		if field.GetAStar() != nil {
+			if err := c.star(candidates); err != nil {
+				return err
+			}
+		} else if s := field.GetString_(); s != nil {
+			if err := c.column(AccessRead, s.Sval, candidates); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// join checks the columns a USING clause compares against both sides of the
+// join. NATURAL joins compare columns nobody named, so they are rejected when
+// a side has restricted columns.
+func (c *accessChecker) join(j *pg_query.JoinExpr) error {
+	sides := append(c.joinedTables(j.Larg), c.joinedTables(j.Rarg)...)
+	if j.IsNatural {
+		for _, t := range sides {
+			if hasColumnRules(AccessRead, t.schema, t.table) {
+				return Errorf("err_restricted_natural_join", "access denied: %s.%s has restricted columns, join it with ON or USING instead of NATURAL", t.schema, t.table)
+			}
+		}
+	}
+	for _, name := range j.UsingClause {
+		if err := c.column(AccessRead, name.GetString_().GetSval(), sides); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinedTables returns the tables one side of a join reads
+func (c *accessChecker) joinedTables(node *pg_query.Node) []*aclTable {
+	if node == nil {
+		return nil
+	}
+	var tables []*aclTable
+	walkNodes(node.ProtoReflect(), func(m protoreflect.ProtoMessage) bool {
+		if rv, ok := m.(*pg_query.RangeVar); ok {
+			tables = append(tables, c.lookup(rangeVarName(rv))...)
+		}
+		return true
+	})
+	return tables
+}
+
+// insertColumns checks the target columns of an INSERT, which must be listed
+// when the table has restricted columns, and those an ON CONFLICT DO UPDATE
+// sets or tests
+func (c *accessChecker) insertColumns(stmt *pg_query.InsertStmt) error {
+	targets := c.lookup(rangeVarName(stmt.Relation))
+	if len(stmt.Cols) == 0 {
+		for _, t := range targets {
+			if hasColumnRules(AccessWrite, t.schema, t.table) {
+				return Errorf("err_restricted_insert", "access denied: %s.%s has restricted columns, list the columns to insert", t.schema, t.table)
+			}
+		}
+	}
+	for _, col := range stmt.Cols {
+		if rt := col.GetResTarget(); rt != nil {
+			if err := c.column(AccessWrite, rt.Name, targets); err != nil {
+				return err
+			}
+		}
+	}
+
+	conflict := stmt.OnConflictClause
+	if conflict == nil {
+		return nil
+	}
+	for _, target := range conflict.TargetList {
+		if rt := target.GetResTarget(); rt != nil {
+			if err := c.column(AccessWrite, rt.Name, targets); err != nil {
+				return err
+			}
+		}
+	}
+	if conflict.WhereClause == nil {
+		return nil
+	}
+	var err error
+	walkNodes(conflict.WhereClause.ProtoReflect(), func(m protoreflect.ProtoMessage) bool {
+		if ref, ok := m.(*pg_query.ColumnRef); ok && len(ref.Fields) > 0 {
+			if name := ref.Fields[len(ref.Fields)-1].GetString_(); name != nil {
+				err = c.column(AccessWrite, name.Sval, targets)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// checkFunction rejects calls of functions that can read arbitrary relations
+func checkFunction(call *pg_query.FuncCall) error {
+	if len(call.Funcname) == 0 {
+		return nil
+	}
+	name := call.Funcname[len(call.Funcname)-1].GetString_().GetSval()
+	if FunctionAllowed(name) {
+		return nil
+	}
+	return Errorf("err_function_access_denied", "access denied: %s can read any table or file and is not permitted while read rules are set", name)
+}
+
+func rangeVarName(rv *pg_query.RangeVar) string {
+	if rv == nil {
+		return ""
+	}
+	return qualifiedName(rv.Schemaname, rv.Relname)
+}
+
+// qualifiedName returns schema.table, or table when schema is empty
+func qualifiedName(schema, table string) string {
+	if schema != "" {
+		return schema + "." + table
+	}
+	return table
+}
+
+// resolveSchemas fills in the schema of unqualified tables. Since the session
+// search_path is not known in advance, a name is checked against every schema
+// holding a relation of that name; names that do not exist yet belong to
+// current_schema().
+func resolveSchemas(ctx context.Context, tables []*aclTable) ([]*aclTable, error) {
+	var names []string
+	for _, t := range tables {
+		if t.schema == "" {
+			names = append(names, t.table)
+		}
+	}
+	if len(names) == 0 {
+		return tables, nil
+	}
+
+	db, err := GetDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Name   string `db:"name"`
+		Schema string `db:"schema"`
+	}
+	err = db.SelectContext(ctx, &rows, `
+		SELECT t.name, COALESCE(n.nspname, current_schema(), 'public') AS schema
+		FROM unnest($1::text[]) AS t(name)
+		LEFT JOIN pg_class c ON c.relname = t.name
+		LEFT JOIN pg_namespace n ON n.oid = c.relnamespace`, names)
+	if err != nil {
+		return nil, Errorf("err_resolve_schemas", "failed to resolve table schemas: %v", err)
+	}
+
+	var resolved []*aclTable
+	for _, t := range tables {
+		if t.schema != "" {
+			resolved = append(resolved, t)
+			continue
+		}
+		for _, row := range rows {
+			if row.Name == t.table {
+				candidate := *t
+				candidate.schema = row.Schema
+				resolved = append(resolved, &candidate)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// walkNodes calls fn for m and every message nested in it until fn returns false
+func walkNodes(m protoreflect.Message, fn func(protoreflect.ProtoMessage) bool) bool {
+	if !fn(m.Interface()) {
+		return false
+	}
+	ok := true
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind || fd.IsMap() {
+			return true
+		}
+		if fd.IsList() {
+			list := v.List()
+			for i := 0; i < list.Len() && ok; i++ {
+				ok = walkNodes(list.Get(i).Message(), fn)
+			}
+			return ok
+		}
+		ok = walkNodes(v.Message(), fn)
+		return ok
+	})
+	return ok
+}
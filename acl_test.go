@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// withACLs sets the ACL globals for the duration of a test
+func withACLs(t *testing.T, read, write ACL, functions ...string) {
+	t.Helper()
+	oldRead, oldWrite, oldFunctions := ReadACL, WriteACL, AllowedFunctions
+	ReadACL, WriteACL, AllowedFunctions = read, write, functions
+	t.Cleanup(func() {
+		ReadACL, WriteACL, AllowedFunctions = oldRead, oldWrite, oldFunctions
+	})
+}
+
+func TestParseACLPatterns(t *testing.T) {
+	tests := []struct {
+		value   string
+		allow   bool
+		want    int
+		wantErr bool
+	}{
+		{"", false, 0, false},
+		{"billing, public.users", true, 2, false},
+		{"public.users.password_hash", false, 1, false},
+		{"public.users.password_hash", true, 0, true},
+		{"a.b.c.d", false, 0, true},
+		{"[", false, 0, true},
+	}
+	for _, tt := range tests {
+		patterns, err := ParseACLPatterns(tt.value, tt.allow)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseACLPatterns(%q, %v) error = %v, want error %v", tt.value, tt.allow, err, tt.wantErr)
+			continue
+		}
+		if len(patterns) != tt.want {
+			t.Errorf("ParseACLPatterns(%q, %v) = %v, want %d patterns", tt.value, tt.allow, patterns, tt.want)
+		}
+	}
+}
+
+func TestTableAllowed(t *testing.T) {
+	withACLs(t,
+		ACL{Allow: []string{"public", "reporting.*"}, Deny: []string{"public.secrets", "public.users.ssn"}},
+		ACL{Deny: []string{"billing*"}},
+	)
+	tests := []struct {
+		mode, schema, table string
+		want                bool
+	}{
+		{AccessRead, "public", "orders", true},
+		{AccessRead, "public", "secrets", false},
+		{AccessRead, "public", "users", true}, // Column rules do not hide the table
+		{AccessRead, "reporting", "daily", true},
+		{AccessRead, "billing", "invoices", false},
+		{AccessRead, "pg_catalog", "pg_class", true},
+		{AccessRead, "pg_catalog", "pg_stats", false},
+		{AccessRead, "pg_catalog", "pg_stats_ext", false},
+		{AccessRead, "pg_catalog", "pg_statistic_ext_data", false},
+		{AccessRead, "information_schema", "columns", true},
+		{AccessWrite, "public", "orders", true},
+		{AccessWrite, "billing", "invoices", false},
+		{AccessWrite, "billing_archive", "invoices", false},
+	}
+	for _, tt := range tests {
+		if got := TableAllowed(tt.mode, tt.schema, tt.table); got != tt.want {
+			t.Errorf("TableAllowed(%s, %s.%s) = %v, want %v", tt.mode, tt.schema, tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestColumnAllowed(t *testing.T) {
+	withACLs(t, ACL{Deny: []string{"public.users.ssn", "*.*.password*"}}, ACL{})
+	tests := []struct {
+		schema, table, column string
+		want                  bool
+	}{
+		{"public", "users", "email", true},
+		{"public", "users", "ssn", false},
+		{"public", "orders", "ssn", true},
+		{"auth", "accounts", "password_hash", false},
+	}
+	for _, tt := range tests {
+		if got := ColumnAllowed(AccessRead, tt.schema, tt.table, tt.column); got != tt.want {
+			t.Errorf("ColumnAllowed(%s.%s.%s) = %v, want %v", tt.schema, tt.table, tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	withACLs(t, ACL{Deny: []string{"billing*", "public.users.ssn"}}, ACL{}, "pg_stat_file")
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"SELECT id FROM public.orders", false},
+		{"SELECT * FROM billing.invoices", true},
+		{"SELECT email FROM public.users", false},
+		{"SELECT ssn FROM public.users", true},
+		{"SELECT u.ssn FROM public.users u", true},
+		{"SELECT * FROM public.users", true},
+		{"SELECT (public.users).ssn FROM public.users", true},
+		{"SELECT (public.users).email FROM public.users", true},
+		{"SELECT (u).ssn FROM public.users u", true},
+		{"SELECT (row_to_json(u)).ssn FROM public.users u", true},
+		{"SELECT (public.orders).id FROM public.orders", false},
+		{"SELECT (public.orders).* FROM public.orders", false},
+		{"SELECT lower(email) FROM public.users", false},
+		// Comparing a denied column without naming it in the select list
+		{"SELECT count(*) FROM (VALUES ('1')) v(ssn) JOIN public.users USING (ssn)", true},
+		{"SELECT count(*) FROM public.orders o JOIN public.users u USING (id)", false},
+		{"SELECT count(*) FROM (VALUES ('1')) v(ssn) NATURAL JOIN public.users", true},
+		{"SELECT count(*) FROM public.orders NATURAL JOIN (VALUES (1)) v(id)", false},
+		{"SELECT count(*) FROM public.orders o JOIN (public.users u JOIN public.orders p ON true) USING (ssn)", true},
+		// Functions reading relations named by an argument or SQL text
+		{"SELECT table_to_xml('billing.invoices', true, false, '')", true},
+		{"SELECT query_to_xml('select * from billing.invoices', true, false, '')", true},
+		{"SELECT query_to_xml_and_xmlschema('select 1', true, false, '')", true},
+		{"SELECT cursor_to_xml('c', 10, true, false, '')", true},
+		{"SELECT database_to_xml(true, false, '')", true},
+		{"SELECT pg_catalog.TABLE_TO_XML('billing.invoices', true, false, '')", true},
+		{"SELECT * FROM dblink('dbname=app', 'select * from billing.invoices') AS t(id int)", true},
+		{"SELECT lo_export(16384, '/tmp/out')", true},
+		{"SELECT pg_read_file('/etc/passwd')", true},
+		{"SELECT ts_stat('select body from billing.notes')", true},
+		{"SELECT id FROM public.orders WHERE id IN (SELECT length(query_to_xml('select 1', true, false, '')::text))", true},
+		// Explicitly allowed and harmless functions
+		{"SELECT pg_stat_file('postgresql.conf')", false},
+		{"SELECT now(), count(*) FROM public.orders", false},
+		// Statistics sample the values of denied tables
+		{"SELECT most_common_vals FROM pg_catalog.pg_stats WHERE tablename = 'invoices'", true},
+		{"SELECT stxdmcv FROM pg_catalog.pg_statistic_ext_data", true},
+		{"SELECT relname FROM pg_catalog.pg_class", false},
+	}
+	for _, tt := range tests {
+		err := CheckAccess(context.Background(), tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckAccess(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFunctionAllowedWithoutReadRules(t *testing.T) {
+	withACLs(t, ACL{}, ACL{Deny: []string{"billing"}})
+	if !FunctionAllowed("table_to_xml") {
+		t.Error("table_to_xml refused although no read rule is set")
+	}
+	if !TableAllowed(AccessRead, "pg_catalog", "pg_stats") {
+		t.Error("pg_stats refused although no read rule is set")
+	}
+	if err := CheckAccess(context.Background(), "SELECT table_to_xml('billing.invoices', true, false, '')"); err != nil {
+		t.Errorf("CheckAccess without read rules: %v", err)
+	}
+}
+
+func TestCheckAccessWrites(t *testing.T) {
+	withACLs(t, ACL{}, ACL{Deny: []string{"billing*", "public.users.ssn"}})
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"INSERT INTO public.users (id, email) VALUES (1, 'a')", false},
+		{"INSERT INTO public.users (id, ssn) VALUES (1, 'x')", true},
+		{"INSERT INTO public.users VALUES (1, 'a')", true},
+		{"INSERT INTO billing.invoices (id) VALUES (1)", true},
+		{"UPDATE public.users SET ssn = 'x' WHERE id = 1", true},
+		{"UPDATE public.users SET email = 'x' WHERE id = 1", false},
+		// Upserts write the columns of their DO UPDATE clause
+		{"INSERT INTO public.users (id) VALUES (1) ON CONFLICT (id) DO UPDATE SET email = excluded.email", false},
+		{"INSERT INTO public.users (id) VALUES (1) ON CONFLICT (id) DO UPDATE SET ssn = 'x'", true},
+		{"INSERT INTO public.users AS u (id) VALUES (1) ON CONFLICT (id) DO UPDATE SET email = 'x' WHERE u.ssn = 'x'", true},
+		{"INSERT INTO public.users (id) VALUES (1) ON CONFLICT DO NOTHING", false},
+	}
+	for _, tt := range tests {
+		err := CheckAccess(context.Background(), tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckAccess(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+var (
+	AuditFile       string // JSON lines file every tool call is appended to
+	AuditTable      string // schema.table every tool call is inserted into
+	AuditConnection string // Connection holding AuditTable (default: the default connection)
+	// AuditRedactLiterals replaces literal values in audited SQL with $n placeholders
+	AuditRedactLiterals bool
+
+	auditMu   sync.Mutex
+	auditFile *os.File
+	auditDB   *sqlx.DB
+)
+
+// auditTimeout bounds writing one entry to the audit table
+const auditTimeout = 5 * time.Second
+
+// Arguments holding SQL, normalized like the statements themselves
+var auditSQLArguments = map[string]string{
+	"query":        "",
+	"where_clause": "SELECT WHERE ",
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time          time.Time              `json:"time"`
+	SessionID     string                 `json:"session_id,omitempty"`
+	ClientName    string                 `json:"client_name,omitempty"`
+	ClientVersion string                 `json:"client_version,omitempty"`
+	Identity      string                 `json:"identity,omitempty"`
+	Tool          string                 `json:"tool"`
+	Arguments     map[string]interface{} `json:"arguments,omitempty"`
+	Statements    []AuditStatement       `json:"statements,omitempty"`
+	RowsReturned  *int                   `json:"rows_returned,omitempty"`
+	RowsAffected  *int64                 `json:"rows_affected,omitempty"`
+	DurationMs    float64                `json:"duration_ms"`
+	Error         string                 `json:"error,omitempty"`
+}
+
+// AuditStatement is a statement a tool call sent to the database
+type AuditStatement struct {
+	SQL          string `json:"sql"`
+	Type         string `json:"type"`
+	ModifiesData bool   `json:"modifies_data"`
+}
+
+type auditKey struct{}
+
+// auditRecorder collects the statements of one tool call
+type auditRecorder struct {
+	mu         sync.Mutex
+	statements []AuditStatement
+}
+
+// AuditEnabled reports whether an audit log is configured
+func AuditEnabled() bool {
+	return AuditFile != "" || AuditTable != ""
+}
+
+// InitAudit opens the audit file and creates the audit table if needed
+func InitAudit() error {
+	if AuditFile != "" {
+		f, err := os.OpenFile(AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %v", err)
+		}
+		auditFile = f
+	}
+
+	if AuditTable == "" {
+		return nil
+	}
+	name := AuditConnection
+	if name == "" {
+		name = DefaultConnection
+	}
+	conn, ok := connections[name]
+	if !ok {
+		return fmt.Errorf("audit connection %q is not defined", name)
+	}
+	parts := strings.Split(AuditTable, ".")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid audit table %q (use table or schema.table)", AuditTable)
+	}
+
+	// A pool of its own, since a read-only server must still record its calls
+	db := sqlx.NewDb(stdlib.OpenDB(*conn.config.Copy()), "pgx")
+	db.SetMaxOpenConns(2)
+	ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id bigserial PRIMARY KEY,
+		logged_at timestamptz NOT NULL,
+		session_id text,
+		client_name text,
+		client_version text,
+		identity text,
+		tool text NOT NULL,
+		arguments jsonb,
+		statements jsonb,
+		rows_returned integer,
+		rows_affected bigint,
+		duration_ms double precision NOT NULL,
+		error text
+	)`, QuoteIdentifier(parts...)))
+	if err == nil {
+		// Tables created before authentication existed lack the identity column
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS identity text", QuoteIdentifier(parts...)))
+	}
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create audit table %s: %v", AuditTable, err)
+	}
+	auditDB = db
+	return nil
+}
+
+// AuditMiddleware records every tool call with its arguments, the statements
+// it ran and its outcome. It runs outermost so rejected calls are logged too.
+func AuditMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if !AuditEnabled() {
+		return next
+	}
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		recorder := &auditRecorder{}
+		start := time.Now()
+		result, err := next(context.WithValue(ctx, auditKey{}, recorder), request)
+
+		entry := &AuditEntry{
+			Time:       start.UTC(),
+			Tool:       request.Params.Name,
+			Arguments:  auditArguments(request.GetArguments()),
+			Statements: recorder.statements,
+			DurationMs: durationMs(time.Since(start)),
+		}
+		if identity := IdentityFromContext(ctx); identity != nil {
+			entry.Identity = identity.Name
+		}
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			entry.SessionID = session.SessionID()
+			if withInfo, ok := session.(server.SessionWithClientInfo); ok {
+				info := withInfo.GetClientInfo()
+				entry.ClientName, entry.ClientVersion = info.Name, info.Version
+			}
+		}
+		switch {
+		case err != nil, result != nil && result.IsError:
+			entry.Error = auditError(err, result)
+		case result != nil:
+			switch structured := result.StructuredContent.(type) {
+			case *QueryResult:
+				entry.RowsReturned = &structured.RowCount
+			case *ExecResult:
+				entry.RowsAffected = &structured.RowsAffected
+			}
+		}
+		WriteAudit(entry)
+
+		return result, err
+	}
+}
+
+// AuditSQL records a statement sent to the database on behalf of the current tool call
+func AuditSQL(ctx context.Context, query string) {
+	recorder, ok := ctx.Value(auditKey{}).(*auditRecorder)
+	if !ok {
+		return
+	}
+
+	statement := AuditStatement{SQL: auditQuery(query, ""), Type: StatementTypeOther}
+	if stmt, err := ClassifyStatement(query); err == nil {
+		statement.Type = stmt.Type
+		statement.ModifiesData = stmt.ModifiesData
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.statements = append(recorder.statements, statement)
+}
+
+// WriteAudit appends entry to the audit file and table. Failures are logged
+// but never fail the tool call.
+func WriteAudit(entry *AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Audit: failed to encode entry: %v", err)
+		return
+	}
+
+	if auditFile != nil {
+		auditMu.Lock()
+		_, err := auditFile.Write(append(data, '\n'))
+		auditMu.Unlock()
+		if err != nil {
+			log.Printf("Audit: failed to write %s: %v", AuditFile, err)
+		}
+	}
+
+	if auditDB != nil {
+		arguments, _ := json.Marshal(entry.Arguments)
+		statements, _ := json.Marshal(entry.Statements)
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		defer cancel()
+		_, err := auditDB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s
+			(logged_at, session_id, client_name, client_version, identity, tool, arguments, statements, rows_returned, rows_affected, duration_ms, error)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NULLIF($12, ''))`,
+			QuoteIdentifier(strings.Split(AuditTable, ".")...)),
+			entry.Time, entry.SessionID, entry.ClientName, entry.ClientVersion, entry.Identity, entry.Tool,
+			string(arguments), string(statements), entry.RowsReturned, entry.RowsAffected, entry.DurationMs, entry.Error)
+		if err != nil {
+			log.Printf("Audit: failed to insert into %s: %v", AuditTable, err)
+		}
+	}
+}
+
+// auditArguments copies the call arguments, normalizing SQL arguments
+func auditArguments(args map[string]interface{}) map[string]interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if text, ok := value.(string); ok {
+			if prefix, isSQL := auditSQLArguments[key]; isSQL {
+				value = auditQuery(text, prefix)
+			} else {
+				value = Redact(text)
+			}
+		}
+		copied[key] = value
+	}
+	return copied
+}
+
+// auditQuery returns query with its literals replaced by $n placeholders when
+// AuditRedactLiterals is set. A fragment such as a WHERE clause is normalized
+// behind prefix; SQL that cannot be parsed is dropped rather than leaked.
+func auditQuery(query, prefix string) string {
+	if !AuditRedactLiterals {
+		return Redact(query)
+	}
+	normalized, err := pg_query.Normalize(prefix + query)
+	if err != nil || !strings.HasPrefix(normalized, prefix) {
+		return RedactedValue
+	}
+	return Redact(strings.TrimPrefix(normalized, prefix))
+}
+
+// auditError returns the error of a failed call. With AuditRedactLiterals
+// PostgreSQL errors are reduced to their SQLSTATE and its class, since their
+// message, caret lines and DETAIL may quote values of the statement, e.g.
+// invalid input syntax for type integer: "<value>".
+func auditError(err error, result *mcp.CallToolResult) string {
+	var info *QueryErrorInfo
+	var text string
+	if err != nil {
+		text = err.Error()
+		var qerr *QueryError
+		if errors.As(err, &qerr) {
+			info = qerr.Info()
+		}
+	} else {
+		text = resultText(result)
+		info, _ = result.StructuredContent.(*QueryErrorInfo)
+	}
+
+	if AuditRedactLiterals {
+		if info != nil {
+			text = fmt.Sprintf("%s: %s (SQLSTATE %s)", info.Severity, SQLStateClass(info.Code), info.Code)
+		} else {
+			text, _, _ = strings.Cut(text, "\n")
+		}
+	}
+	return Redact(text)
+}
+
+// resultText joins the text content of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
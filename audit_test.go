@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestAuditError(t *testing.T) {
+	old := AuditRedactLiterals
+	t.Cleanup(func() { AuditRedactLiterals = old })
+
+	query := "INSERT INTO people (ssn) VALUES ('123-45-6789')"
+	qerr := &QueryError{Query: query, Err: &pgconn.PgError{
+		Severity: "ERROR", Code: "23505", Position: 33,
+		Message: `duplicate key value violates unique constraint "people_ssn_key"`,
+		Detail:  "Key (ssn)=(123-45-6789) already exists.",
+	}}
+	syntaxErr := &QueryError{Query: "SELECT '123-45-6789'::int", Err: &pgconn.PgError{
+		Severity: "ERROR", Code: "22P02", Position: 8,
+		Message: `invalid input syntax for type integer: "123-45-6789"`,
+	}}
+	tests := []struct {
+		name   string
+		err    error
+		result *mcp.CallToolResult
+		redact bool
+		want   string
+	}{
+		{"unique violation", qerr, nil, true, "ERROR: integrity constraint violation (SQLSTATE 23505)"},
+		{"invalid input syntax", syntaxErr, nil, true, "ERROR: data exception (SQLSTATE 22P02)"},
+		{"error result", nil, ErrorResult(qerr), true, "ERROR: integrity constraint violation (SQLSTATE 23505)"},
+		{"invalid input syntax result", nil, ErrorResult(syntaxErr), true, "ERROR: data exception (SQLSTATE 22P02)"},
+		{"other error", errors.New("failed to parse query\nLINE 1: '123-45-6789'"), nil, true, "failed to parse query"},
+		{"literals kept", qerr, nil, false, qerr.Error()},
+	}
+	for _, tt := range tests {
+		AuditRedactLiterals = tt.redact
+		err := tt.err
+		var got string
+		if err == nil {
+			got = auditError(nil, tt.result)
+		} else {
+			got = auditError(err, nil)
+		}
+		if got != tt.want {
+			t.Errorf("%s: auditError = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.redact && strings.Contains(got, "6789") {
+			t.Errorf("%s: audited error leaks the literal: %q", tt.name, got)
+		}
+	}
+}
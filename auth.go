@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Permission profiles of authenticated identities
+const (
+	ProfileReadOnly  = "read-only"
+	ProfileReadWrite = "read-write"
+)
+
+// ProtectedResourcePath serves the OAuth protected resource metadata (RFC 9728)
+const ProtectedResourcePath = "/.well-known/oauth-protected-resource"
+
+var (
+	AuthTokens   = map[string]string{} // Static bearer tokens and API keys to the identities they authenticate
+	AuthJWKSFile string                // JSON Web Key Set that JWT access tokens are verified against
+	AuthIssuer   string                // Required "iss" of JWTs, advertised as authorization server
+	AuthAudience string                // Required "aud" of JWTs, the canonical URI of this server
+	// AuthIdentityClaim names the JWT claim identifying the caller
+	AuthIdentityClaim string
+	// AuthReadWrite holds identity patterns granted the read-write profile;
+	// every other identity is read-only
+	AuthReadWrite []string
+	// AuthWriteScope grants the read-write profile to JWTs carrying it
+	AuthWriteScope string
+
+	authKeys *jose.JSONWebKeySet
+)
+
+// Signature algorithms accepted for JWTs; "none" and HMAC never are
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Identity is an authenticated caller of the network transports
+type Identity struct {
+	Name    string
+	Method  string // "token" or "jwt"
+	Profile string
+}
+
+type identityKey struct{}
+
+// AuthTokenFlag collects repeated --auth-token identity=token flags
+type AuthTokenFlag []string
+
+func (f *AuthTokenFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *AuthTokenFlag) Set(value string) error {
+	identity, token, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected identity=token")
+	}
+	*f = append(*f, identity)
+	return AddAuthToken(strings.TrimSpace(identity), strings.TrimSpace(token))
+}
+
+// AddAuthToken registers a static token authenticating identity
+func AddAuthToken(identity, token string) error {
+	if identity == "" || token == "" {
+		return fmt.Errorf("auth tokens need an identity and a token")
+	}
+	if _, ok := AuthTokens[token]; ok {
+		return fmt.Errorf("auth token of %q is already used by another identity", identity)
+	}
+	AddSecret(token)
+	AuthTokens[token] = identity
+	return nil
+}
+
+// AuthEnabled reports whether the network transports require authentication
+func AuthEnabled() bool {
+	return len(AuthTokens) > 0 || AuthJWKSFile != ""
+}
+
+// InitAuth loads the JWKS
+func InitAuth() error {
+	if AuthJWKSFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(AuthJWKSFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %v", err)
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, keys); err != nil {
+		return fmt.Errorf("failed to parse JWKS %s: %v", AuthJWKSFile, err)
+	}
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("JWKS %s holds no keys", AuthJWKSFile)
+	}
+	for _, key := range keys.Keys {
+		if !key.IsPublic() {
+			return fmt.Errorf("JWKS %s holds a private or symmetric key (%s), only public keys are accepted", AuthJWKSFile, key.KeyID)
+		}
+	}
+	authKeys = keys
+	return nil
+}
+
+// AuthMiddleware rejects requests without valid credentials and attaches the
+// caller's Identity to the request context. Static tokens are accepted as
+// "Authorization: Bearer <token>" or "X-API-Key: <token>"; any other bearer
+// token must be a JWT signed by a key of the JWKS.
+func AuthMiddleware(next http.Handler) http.Handler {
+	if !AuthEnabled() {
+		return next
+	}
+
+	mux := http.NewServeMux()
+	if authKeys != nil {
+		mux.HandleFunc(ProtectedResourcePath, serveProtectedResource)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		identity, err := authenticate(r)
+		if err != nil {
+			log.Printf("Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			challenge(w, r, err != errNoCredentials)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+	return mux
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate returns the identity behind the credentials of r
+func authenticate(r *http.Request) (*Identity, error) {
+	token := r.Header.Get("X-API-Key")
+	if scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(credentials)
+	}
+	if token == "" {
+		return nil, errNoCredentials
+	}
+
+	for known, name := range AuthTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return &Identity{Name: name, Method: "token", Profile: profileOf(name, nil)}, nil
+		}
+	}
+	if authKeys == nil {
+		return nil, fmt.Errorf("unknown token")
+	}
+	return verifyJWT(token)
+}
+
+// verifyJWT checks the signature, issuer, audience and lifetime of a JWT
+func verifyJWT(token string) (*Identity, error) {
+	parsed, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{} = authKeys
+	// A single key may verify tokens that name no key ID
+	if len(authKeys.Keys) == 1 && parsed.Headers[0].KeyID == "" {
+		key = authKeys.Keys[0]
+	}
+
+	var claims jwt.Claims
+	var extra map[string]interface{}
+	if err := parsed.Claims(key, &claims, &extra); err != nil {
+		return nil, err
+	}
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("JWT has no expiry")
+	}
+	expected := jwt.Expected{Issuer: AuthIssuer, AnyAudience: jwt.Audience{AuthAudience}, Time: time.Now()}
+	if err := claims.Validate(expected); err != nil {
+		return nil, err
+	}
+
+	name, _ := extra[AuthIdentityClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("JWT has no %s claim", AuthIdentityClaim)
+	}
+	return &Identity{Name: name, Method: "jwt", Profile: profileOf(name, jwtScopes(extra))}, nil
+}
+
+// jwtScopes reads the space separated "scope" claim, or the "scp" list some
+// authorization servers issue instead
+func jwtScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if list, ok := claims["scp"].([]interface{}); ok {
+		for _, item := range list {
+			if scope, ok := item.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// profileOf returns the permission profile of identity name
+func profileOf(name string, scopes []string) string {
+	if AuthWriteScope != "" && slices.Contains(scopes, AuthWriteScope) {
+		return ProfileReadWrite
+	}
+	for _, pattern := range AuthReadWrite {
+		if ok, _ := path.Match(pattern, name); ok {
+			return ProfileReadWrite
+		}
+	}
+	return ProfileReadOnly
+}
+
+// challenge answers 401 with a WWW-Authenticate header pointing OAuth clients
+// to the protected resource metadata
+func challenge(w http.ResponseWriter, r *http.Request, invalid bool) {
+	params := []string{`realm="go-postgres-mcp"`}
+	if authKeys != nil {
+		params = append(params, fmt.Sprintf("resource_metadata=%q", requestBaseURL(r)+ProtectedResourcePath))
+	}
+	if invalid {
+		params = append(params, `error="invalid_token"`)
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// serveProtectedResource tells OAuth clients which authorization server
+// issues tokens for this server
+func serveProtectedResource(w http.ResponseWriter, r *http.Request) {
+	metadata := map[string]interface{}{
+		"resource":                 AuthAudience,
+		"bearer_methods_supported": []string{"header"},
+	}
+	if AuthIssuer != "" {
+		metadata["authorization_servers"] = []string{AuthIssuer}
+	}
+	if AuthWriteScope != "" {
+		metadata["scopes_supported"] = []string{AuthWriteScope}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
+
+// requestBaseURL returns the scheme and host r was sent to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// IdentityFromContext returns the authenticated caller, or nil without auth
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+type readOnlyKey struct{}
+
+// ReadOnlyContext marks the statements run with the returned context as
+// read-only, whoever the caller is
+func ReadOnlyContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// IsReadOnly reports whether the current call may not write, because the
+// server runs with --read-only, the caller has the read-only profile or ctx
+// comes from ReadOnlyContext
+func IsReadOnly(ctx context.Context) bool {
+	if ReadOnly || ctx.Value(readOnlyKey{}) != nil {
+		return true
+	}
+	identity := IdentityFromContext(ctx)
+	return identity != nil && identity.Profile == ProfileReadOnly
+}
+
+// CheckWriteAllowed rejects writes by callers with the read-only profile
+func CheckWriteAllowed(ctx context.Context) error {
+	if identity := IdentityFromContext(ctx); identity != nil && identity.Profile == ProfileReadOnly {
+		return Errorf("err_read_only_identity", "%s may only read (profile %s)", identity.Name, ProfileReadOnly)
+	}
+	return nil
+}
+
+// FilterWriteTools hides the write tools from read-only callers
+func FilterWriteTools(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	if !IsReadOnly(ctx) {
+		return tools
+	}
+	var allowed []mcp.Tool
+	for _, tool := range tools {
+		if !slices.Contains(WriteTools, tool.Name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// withAuth configures authentication for the duration of a test; keys are
+// published as a JWKS file when given
+func withAuth(t *testing.T, keys ...jose.JSONWebKey) {
+	t.Helper()
+	oldTokens, oldJWKS, oldKeys := AuthTokens, AuthJWKSFile, authKeys
+	oldIssuer, oldAudience, oldClaim := AuthIssuer, AuthAudience, AuthIdentityClaim
+	oldReadWrite, oldScope := AuthReadWrite, AuthWriteScope
+	t.Cleanup(func() {
+		AuthTokens, AuthJWKSFile, authKeys = oldTokens, oldJWKS, oldKeys
+		AuthIssuer, AuthAudience, AuthIdentityClaim = oldIssuer, oldAudience, oldClaim
+		AuthReadWrite, AuthWriteScope = oldReadWrite, oldScope
+	})
+
+	AuthTokens, AuthJWKSFile, authKeys = map[string]string{}, "", nil
+	AuthIssuer, AuthAudience, AuthIdentityClaim = "https://issuer.example", "https://mcp.example", "sub"
+	AuthReadWrite, AuthWriteScope = []string{"admin-*"}, "db:write"
+	if len(keys) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AuthJWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(AuthJWKSFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitAuth(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newSigningKey(t *testing.T, kid string) (*ecdsa.PrivateKey, jose.JSONWebKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jose.JSONWebKey{Key: &key.PublicKey, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}
+}
+
+// signJWT signs claims with key under alg, naming kid in the header if set
+func signJWT(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	signingKey := jose.SigningKey{Algorithm: alg, Key: key}
+	if kid != "" {
+		signingKey.Key = jose.JSONWebKey{Key: key, KeyID: kid}
+	}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func validClaims(overrides map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub": "alice",
+		"iss": "https://issuer.example",
+		"aud": "https://mcp.example",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestVerifyJWT(t *testing.T) {
+	keyA, jwkA := newSigningKey(t, "a")
+	keyB, jwkB := newSigningKey(t, "b")
+	stranger, _ := newSigningKey(t, "a")
+	withAuth(t, jwkA, jwkB)
+
+	past, future := time.Now().Add(-time.Hour).Unix(), time.Now().Add(time.Hour).Unix()
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(mustJSON(t, validClaims(nil))) + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		want    string // Identity name, empty when the token must be rejected
+		profile string
+	}{
+		{"valid", signJWT(t, jose.ES256, keyA, "a", validClaims(nil)), "alice", ProfileReadOnly},
+		{"second key", signJWT(t, jose.ES256, keyB, "b", validClaims(nil)), "alice", ProfileReadOnly},
+		{"write scope", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"scope": "openid db:write"})), "alice", ProfileReadWrite},
+		{"write scope as scp list", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"scp": []string{"db:write"}})), "alice", ProfileReadWrite},
+		{"other scopes", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"scope": "db:write:extra"})), "alice", ProfileReadOnly},
+		{"read-write identity", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"sub": "admin-bob"})), "admin-bob", ProfileReadWrite},
+		{"alg none", unsigned, "", ""},
+		{"HS256 keyed with the public key", signJWT(t, jose.HS256, mustJSON(t, jwkA), "a", validClaims(nil)), "", ""},
+		{"unknown kid", signJWT(t, jose.ES256, keyA, "c", validClaims(nil)), "", ""},
+		{"kid of another key", signJWT(t, jose.ES256, keyA, "b", validClaims(nil)), "", ""},
+		{"no kid with several keys", signJWT(t, jose.ES256, keyA, "", validClaims(nil)), "", ""},
+		{"foreign key", signJWT(t, jose.ES256, stranger, "a", validClaims(nil)), "", ""},
+		{"wrong audience", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"aud": "https://other.example"})), "", ""},
+		{"no audience", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"aud": nil})), "", ""},
+		{"wrong issuer", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"iss": "https://evil.example"})), "", ""},
+		{"expired", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"exp": past})), "", ""},
+		{"no expiry", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"exp": nil})), "", ""},
+		{"not yet valid", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"nbf": future})), "", ""},
+		{"no identity claim", signJWT(t, jose.ES256, keyA, "a", validClaims(map[string]interface{}{"sub": nil})), "", ""},
+		{"garbage", "not.a.jwt", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := verifyJWT(tt.token)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("verifyJWT accepted the token as %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT: %v", err)
+			}
+			if identity.Name != tt.want || identity.Profile != tt.profile || identity.Method != "jwt" {
+				t.Errorf("identity = %+v, want %s with profile %s", identity, tt.want, tt.profile)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSingleKeyWithoutKid(t *testing.T) {
+	key, jwk := newSigningKey(t, "only")
+	withAuth(t, jwk)
+	if _, err := verifyJWT(signJWT(t, jose.ES256, key, "", validClaims(nil))); err != nil {
+		t.Errorf("verifyJWT without kid against a single key: %v", err)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInitAuthRejectsPrivateKeys(t *testing.T) {
+	key, _ := newSigningKey(t, "a")
+	withAuth(t)
+	data := mustJSON(t, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key, KeyID: "a"}}})
+	AuthJWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(AuthJWKSFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitAuth(); err == nil {
+		t.Error("InitAuth accepted a private key")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	key, jwk := newSigningKey(t, "a")
+	withAuth(t, jwk)
+	if err := AddAuthToken("ci", "ci-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddAuthToken("admin-ops", "ops-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddAuthToken("other", "ci-secret"); err == nil {
+		t.Error("AddAuthToken accepted a token used by another identity")
+	}
+
+	jwtToken := signJWT(t, jose.ES256, key, "a", validClaims(nil))
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+		profile string
+		wantErr bool
+	}{
+		{"bearer token", map[string]string{"Authorization": "Bearer ci-secret"}, "ci", ProfileReadOnly, false},
+		{"bearer scheme is case insensitive", map[string]string{"Authorization": "bearer ci-secret"}, "ci", ProfileReadOnly, false},
+		{"api key", map[string]string{"X-API-Key": "ops-secret"}, "admin-ops", ProfileReadWrite, false},
+		{"bearer wins over api key", map[string]string{"Authorization": "Bearer ops-secret", "X-API-Key": "ci-secret"}, "admin-ops", ProfileReadWrite, false},
+		{"api key prefix", map[string]string{"X-API-Key": "ci-secre"}, "", "", true},
+		{"api key suffix", map[string]string{"X-API-Key": "ci-secret2"}, "", "", true},
+		{"basic auth", map[string]string{"Authorization": "Basic Y2k6Y2ktc2VjcmV0"}, "", "", true},
+		{"no credentials", nil, "", "", true},
+		{"jwt", map[string]string{"Authorization": "Bearer " + jwtToken}, "alice", ProfileReadOnly, false},
+		{"jwt as api key", map[string]string{"X-API-Key": jwtToken}, "alice", ProfileReadOnly, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			identity, err := authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticate error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (identity.Name != tt.want || identity.Profile != tt.profile) {
+				t.Errorf("identity = %+v, want %s with profile %s", identity, tt.want, tt.profile)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	_, jwk := newSigningKey(t, "a")
+	withAuth(t, jwk)
+	if err := AddAuthToken("ci", "ci-secret"); err != nil {
+		t.Fatal(err)
+	}
+	var seen *Identity
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = IdentityFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name, path, authorization string
+		status                    int
+		challenge                 string
+	}{
+		{"no credentials", "/mcp", "", http.StatusUnauthorized, `Bearer realm="go-postgres-mcp", resource_metadata="http://mcp.example` + ProtectedResourcePath + `"`},
+		{"bad token", "/mcp", "Bearer nope", http.StatusUnauthorized, `Bearer realm="go-postgres-mcp", resource_metadata="http://mcp.example` + ProtectedResourcePath + `", error="invalid_token"`},
+		{"valid token", "/mcp", "Bearer ci-secret", http.StatusOK, ""},
+		{"metadata needs no token", ProtectedResourcePath, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(http.MethodGet, "http://mcp.example"+tt.path, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+			if tt.name == "valid token" && (seen == nil || seen.Name != "ci") {
+				t.Errorf("handler saw identity %+v, want ci", seen)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	withAuth(t)
+	oldReadOnly := ReadOnly
+	t.Cleanup(func() { ReadOnly = oldReadOnly })
+
+	readOnly := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "ci", Profile: ProfileReadOnly})
+	readWrite := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "admin-ops", Profile: ProfileReadWrite})
+	tools := []mcp.Tool{{Name: "read_query"}, {Name: "write_query"}, {Name: "create_table"}, {Name: "list_tables"}}
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		readOnly   bool
+		wantWrites bool
+	}{
+		{"read-only identity", readOnly, false, false},
+		{"read-write identity", readWrite, false, true},
+		{"no authentication", context.Background(), false, true},
+		{"read-only server", readWrite, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ReadOnly = tt.readOnly
+			if got := !IsReadOnly(tt.ctx); got != tt.wantWrites {
+				t.Errorf("IsReadOnly = %v, want %v", !got, !tt.wantWrites)
+			}
+			filtered := FilterWriteTools(tt.ctx, tools)
+			if wantLen := map[bool]int{true: 4, false: 2}[tt.wantWrites]; len(filtered) != wantLen {
+				t.Errorf("FilterWriteTools left %v, want %d tools", filtered, wantLen)
+			}
+			for _, tool := range filtered {
+				if !tt.wantWrites && (tool.Name == "write_query" || tool.Name == "create_table") {
+					t.Errorf("FilterWriteTools kept %s", tool.Name)
+				}
+			}
+		})
+	}
+
+	ReadOnly = false
+	if err := CheckWriteAllowed(readOnly); err == nil {
+		t.Error("CheckWriteAllowed let a read-only identity write")
+	}
+	if err := CheckWriteAllowed(readWrite); err != nil {
+		t.Errorf("CheckWriteAllowed(read-write): %v", err)
+	}
+	if err := CheckWriteAllowed(context.Background()); err != nil {
+		t.Errorf("CheckWriteAllowed without authentication: %v", err)
+	}
+}
+
+func TestProfileOf(t *testing.T) {
+	withAuth(t)
+	tests := []struct {
+		name   string
+		scopes []string
+		want   string
+	}{
+		{"alice", nil, ProfileReadOnly},
+		{"alice", []string{"openid", "db:write"}, ProfileReadWrite},
+		{"alice", []string{"db:read"}, ProfileReadOnly},
+		{"admin-bob", nil, ProfileReadWrite},
+		{"bob-admin", nil, ProfileReadOnly},
+	}
+	for _, tt := range tests {
+		if got := profileOf(tt.name, tt.scopes); got != tt.want {
+			t.Errorf("profileOf(%s, %v) = %s, want %s", tt.name, tt.scopes, got, tt.want)
+		}
+	}
+
+	AuthWriteScope = ""
+	if got := profileOf("alice", []string{""}); got != ProfileReadOnly {
+		t.Errorf("profileOf with an empty scope = %s, want %s", got, ProfileReadOnly)
+	}
+}
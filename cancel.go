@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// requestIDMetaKey carries the JSON-RPC request ID from the BeforeCallTool hook
+// to QueryContextMiddleware, which otherwise never sees it
+const requestIDMetaKey = "go-postgres-mcp/request-id"
+
+// inflightCalls maps session/request IDs of running tool calls to their cancel funcs
+var inflightCalls sync.Map
+
+func callKey(ctx context.Context, id any) string {
+	return sessionID(ctx) + "/" + requestIDKey(id)
+}
+
+// sessionID returns the ID of the MCP session of ctx, empty outside of one
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
+
+// requestIDKey renders a JSON-RPC request ID the same way whether it was
+// parsed into an mcp.RequestId (int64) or decoded from the untyped params of
+// a notification (float64). String IDs are quoted so "1" and 1 stay distinct.
+func requestIDKey(id any) string {
+	if rid, ok := id.(mcp.RequestId); ok {
+		id = rid.Value()
+	}
+	switch v := id.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return strconv.FormatInt(n, 10)
+		}
+		return v.String()
+	}
+	return fmt.Sprint(id)
+}
+
+// RememberRequestID is a BeforeCallTool hook that records the request ID on
+// the tool call so it can later be matched against notifications/cancelled
+func RememberRequestID(ctx context.Context, id any, request *mcp.CallToolRequest) {
+	if rid, ok := id.(mcp.RequestId); ok {
+		id = rid.Value()
+	}
+	if request.Params.Meta == nil {
+		request.Params.Meta = &mcp.Meta{}
+	}
+	if request.Params.Meta.AdditionalFields == nil {
+		request.Params.Meta.AdditionalFields = map[string]any{}
+	}
+	request.Params.Meta.AdditionalFields[requestIDMetaKey] = id
+}
+
+// QueryContextMiddleware bounds every tool call by --query-timeout or the
+// per-call timeout_ms argument and makes it cancellable by the client
+func QueryContextMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		timeout := QueryTimeout
+		if ms := getNumberParam(request, "timeout_ms", 0); ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+
+		if meta := request.Params.Meta; meta != nil {
+			if id, ok := meta.AdditionalFields[requestIDMetaKey]; ok {
+				key := callKey(ctx, id)
+				inflightCalls.Store(key, cancel)
+				defer inflightCalls.Delete(key)
+			}
+		}
+
+		return next(ctx, request)
+	}
+}
+
+// HandleCancelledNotification cancels the context of the tool call named by
+// a notifications/cancelled message
+func HandleCancelledNotification(ctx context.Context, notification mcp.JSONRPCNotification) {
+	id, ok := notification.Params.AdditionalFields["requestId"]
+	if !ok {
+		return
+	}
+	if cancel, ok := inflightCalls.Load(callKey(ctx, id)); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
+
+// WithConn runs fn on a dedicated connection guarded by GuardStatement
+func WithConn(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	db, err := GetDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release, err := GuardStatement(ctx, db, conn, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(conn)
+}
+
+// GuardStatement applies the context deadline to conn as statement_timeout
+// (transaction-local when local is set) and cancels the backend with
+// pg_cancel_backend if ctx is cancelled before the returned release func runs.
+func GuardStatement(ctx context.Context, db *sqlx.DB, conn *sqlx.Conn, local bool) (func(), error) {
+	var pid int
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			return nil, context.DeadlineExceeded
+		}
+		timeout := fmt.Sprintf("%d", ms)
+		if err := conn.QueryRowxContext(ctx, "SELECT pg_backend_pid(), set_config('statement_timeout', $1, $2)", timeout, local).Scan(&pid, new(string)); err != nil {
+			return nil, err
+		}
+	} else if err := conn.GetContext(ctx, &pid, "SELECT pg_backend_pid()"); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			db.ExecContext(cancelCtx, "SELECT pg_cancel_backend($1)", pid)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		// Wait for the watcher before the connection is reused, so a late
+		// cancel can never hit the next statement on this backend
+		close(done)
+		wg.Wait()
+		if hasDeadline && !local {
+			conn.ExecContext(context.Background(), "RESET statement_timeout")
+		}
+	}, nil
+}
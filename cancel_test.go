@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestRequestIDKey(t *testing.T) {
+	tests := []struct {
+		id   any
+		want string
+	}{
+		{mcp.NewRequestId(int64(1000000)), "1000000"},
+		{float64(1000000), "1000000"},
+		{float64(9007199254740993), "9007199254740992"},
+		{json.Number("1000000"), "1000000"},
+		{mcp.NewRequestId("abc"), `"abc"`},
+		{"abc", `"abc"`},
+		{"1", `"1"`},
+		{1.5, "1.5"},
+	}
+	for _, tt := range tests {
+		if got := requestIDKey(tt.id); got != tt.want {
+			t.Errorf("requestIDKey(%#v) = %s, want %s", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCancelledNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       mcp.RequestId
+		notified string
+		want     bool
+	}{
+		{"large numeric id", mcp.NewRequestId(int64(1000000)), `1000000`, true},
+		{"string id", mcp.NewRequestId("req-7"), `"req-7"`, true},
+		{"string id matching a number", mcp.NewRequestId(int64(7)), `"7"`, false},
+		{"other id", mcp.NewRequestId(int64(8)), `9`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var notification mcp.JSONRPCNotification
+			message := `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":` + tt.notified + `}}`
+			if err := json.Unmarshal([]byte(message), &notification); err != nil {
+				t.Fatal(err)
+			}
+
+			request := mcp.CallToolRequest{}
+			RememberRequestID(context.Background(), tt.id, &request)
+			handler := QueryContextMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				HandleCancelledNotification(ctx, notification)
+				if cancelled := ctx.Err() != nil; cancelled != tt.want {
+					t.Errorf("cancelled = %v, want %v", cancelled, tt.want)
+				}
+				return nil, nil
+			})
+			handler(context.Background(), request)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/pganalyze/pg_query_go/v6/parser"
+)
+
+// Statement describes a single SQL statement as seen by the PostgreSQL parser
+type Statement struct {
+	Type         string // One of the StatementType* constants
+	HasWhere     bool   // Top-level UPDATE/DELETE carries its own WHERE clause
+	ModifyingCTE bool   // A WITH clause contains INSERT/UPDATE/DELETE/MERGE
+	ModifiesData bool   // The statement writes data, schema or row locks
+}
+
+// ClassifyStatement parses query with the PostgreSQL grammar and reports what
+// kind of statement it is. Input containing more than one statement is
+// rejected, except for a CREATE TABLE or ALTER TABLE followed by COMMENT ON
+// statements for that table and its columns.
+func ClassifyStatement(query string) (*Statement, error) {
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		var parseErr *parser.Error
+		if errors.As(err, &parseErr) && parseErr.Cursorpos > 0 {
+			return nil, Errorf("err_parse_query_position", "failed to parse query: %v\n%s", err, caretLines(query, parseErr.Cursorpos, "LINE"))
+		}
+		return nil, Errorf("err_parse_query", "failed to parse query: %v", err)
+	}
+
+	if len(tree.Stmts) == 0 {
+		return nil, Errorf("err_empty_query", "query is empty")
+	}
+	if len(tree.Stmts) > 1 && !commentsFollow(tree.Stmts) {
+		return nil, Errorf("err_multiple_statements", "only a single statement is allowed, got %d", len(tree.Stmts))
+	}
+
+	node := tree.Stmts[0].Stmt
+	stmt := &Statement{Type: StatementTypeOther}
+
+	switch n := node.Node.(type) {
+	case *pg_query.Node_SelectStmt:
+		stmt.Type = StatementTypeSelect
+		stmt.ModifyingCTE = selectHasModifyingCTE(n.SelectStmt)
+		stmt.ModifiesData = stmt.ModifyingCTE || selectWrites(n.SelectStmt)
+	case *pg_query.Node_InsertStmt:
+		stmt.Type = StatementTypeInsert
+		stmt.ModifyingCTE = withClauseModifiesData(n.InsertStmt.WithClause)
+		stmt.ModifiesData = true
+	case *pg_query.Node_UpdateStmt:
+		stmt.Type = StatementTypeUpdate
+		stmt.HasWhere = n.UpdateStmt.WhereClause != nil
+		stmt.ModifyingCTE = withClauseModifiesData(n.UpdateStmt.WithClause)
+		stmt.ModifiesData = true
+	case *pg_query.Node_DeleteStmt:
+		stmt.Type = StatementTypeDelete
+		stmt.HasWhere = n.DeleteStmt.WhereClause != nil
+		stmt.ModifyingCTE = withClauseModifiesData(n.DeleteStmt.WithClause)
+		stmt.ModifiesData = true
+	case *pg_query.Node_CreateStmt:
+		stmt.Type = StatementTypeCreateTable
+		stmt.ModifiesData = true
+	case *pg_query.Node_CreateTableAsStmt:
+		if n.CreateTableAsStmt.Objtype == pg_query.ObjectType_OBJECT_TABLE {
+			stmt.Type = StatementTypeCreateTable
+			stmt.ModifyingCTE = selectHasModifyingCTE(n.CreateTableAsStmt.Query.GetSelectStmt())
+		}
+		stmt.ModifiesData = true
+	case *pg_query.Node_AlterTableStmt:
+		stmt.Type = StatementTypeAlterTable
+		stmt.ModifiesData = true
+	case *pg_query.Node_IndexStmt:
+		stmt.Type = StatementTypeCreateIndex
+		stmt.ModifiesData = true
+	case *pg_query.Node_ExplainStmt:
+		stmt.Type = StatementTypeExplain
+	case *pg_query.Node_CommentStmt:
+		if _, _, _, ok := CommentTarget(n.CommentStmt); ok {
+			stmt.Type = StatementTypeComment
+		}
+		stmt.ModifiesData = true
+	default:
+		stmt.ModifiesData = true
+	}
+
+	return stmt, nil
+}
+
+// ValidateStatement classifies query and checks that it is a single statement
+// of the expected type, free of data-modifying CTEs, and that UPDATE/DELETE
+// statements carry a top-level WHERE clause.
+func ValidateStatement(query, expect string) (*Statement, error) {
+	stmt, err := ClassifyStatement(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comments on tables and columns are part of altering a table
+	if stmt.Type != expect && !(stmt.Type == StatementTypeComment && expect == StatementTypeAlterTable) {
+		return nil, Errorf("err_statement_type", "expected a %s statement, got %s", expect, stmt.Type)
+	}
+
+	if stmt.ModifyingCTE {
+		return nil, Errorf("err_modifying_cte", "data-modifying statements inside WITH are not allowed")
+	}
+
+	switch stmt.Type {
+	case StatementTypeSelect:
+		if stmt.ModifiesData {
+			return nil, Errorf("err_select_modifies", "SELECT statements must not modify data (SELECT INTO or row locking)")
+		}
+	case StatementTypeUpdate, StatementTypeDelete:
+		if !stmt.HasWhere {
+			return nil, Errorf("err_missing_where", "%s queries must include a WHERE clause for safety", stmt.Type)
+		}
+	}
+
+	return stmt, nil
+}
+
+// CommentTarget returns the table, qualified by schema if written so, and
+// column a COMMENT ON TABLE or COMMENT ON COLUMN statement describes
+func CommentTarget(c *pg_query.CommentStmt) (schema, table, column string, ok bool) {
+	var names []string
+	for _, item := range c.Object.GetList().GetItems() {
+		names = append(names, item.GetString_().GetSval())
+	}
+	if c.Objtype == pg_query.ObjectType_OBJECT_COLUMN && len(names) >= 2 {
+		column, names = names[len(names)-1], names[:len(names)-1]
+	} else if c.Objtype != pg_query.ObjectType_OBJECT_TABLE {
+		return "", "", "", false
+	}
+	switch len(names) {
+	case 1:
+		return "", names[0], column, true
+	case 2:
+		return names[0], names[1], column, true
+	}
+	return "", "", "", false
+}
+
+// commentsFollow reports whether stmts is a CREATE TABLE or ALTER TABLE
+// followed only by comments on the same table or its columns
+func commentsFollow(stmts []*pg_query.RawStmt) bool {
+	var rel *pg_query.RangeVar
+	switch n := stmts[0].Stmt.Node.(type) {
+	case *pg_query.Node_CreateStmt:
+		rel = n.CreateStmt.Relation
+	case *pg_query.Node_AlterTableStmt:
+		rel = n.AlterTableStmt.Relation
+	default:
+		return false
+	}
+	for _, raw := range stmts[1:] {
+		comment := raw.Stmt.GetCommentStmt()
+		if comment == nil {
+			return false
+		}
+		schema, table, _, ok := CommentTarget(comment)
+		if !ok || schema != rel.GetSchemaname() || table != rel.GetRelname() {
+			return false
+		}
+	}
+	return true
+}
+
+// selectHasModifyingCTE walks a SELECT, including set operation branches,
+// looking for a data-modifying WITH clause
+func selectHasModifyingCTE(sel *pg_query.SelectStmt) bool {
+	if sel == nil {
+		return false
+	}
+	if withClauseModifiesData(sel.WithClause) {
+		return true
+	}
+	return selectHasModifyingCTE(sel.Larg) || selectHasModifyingCTE(sel.Rarg)
+}
+
+// selectWrites reports SELECT INTO and row-locking clauses such as FOR UPDATE
+func selectWrites(sel *pg_query.SelectStmt) bool {
+	if sel == nil {
+		return false
+	}
+	if sel.IntoClause != nil || len(sel.LockingClause) > 0 {
+		return true
+	}
+	return selectWrites(sel.Larg) || selectWrites(sel.Rarg)
+}
+
+func withClauseModifiesData(with *pg_query.WithClause) bool {
+	if with == nil {
+		return false
+	}
+	for _, cte := range with.Ctes {
+		expr := cte.GetCommonTableExpr()
+		if expr == nil || expr.Ctequery == nil {
+			continue
+		}
+		switch q := expr.Ctequery.Node.(type) {
+		case *pg_query.Node_InsertStmt, *pg_query.Node_UpdateStmt, *pg_query.Node_DeleteStmt, *pg_query.Node_MergeStmt:
+			return true
+		case *pg_query.Node_SelectStmt:
+			if selectHasModifyingCTE(q.SelectStmt) {
+				return true
+			}
+		}
+	}
+	return false
+}
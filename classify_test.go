@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		query        string
+		typ          string
+		modifies     bool
+		modifyingCTE bool
+		wantErr      bool
+	}{
+		{"SELECT 1", StatementTypeSelect, false, false, false},
+		{"SELECT * INTO copy FROM users", StatementTypeSelect, true, false, false},
+		{"SELECT * FROM users FOR UPDATE", StatementTypeSelect, true, false, false},
+		{"SELECT 1 UNION SELECT id FROM users FOR SHARE", StatementTypeSelect, true, false, false},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", StatementTypeSelect, true, true, false},
+		{"(WITH d AS (UPDATE users SET a = 1 RETURNING *) SELECT * FROM d) UNION SELECT 1", StatementTypeSelect, true, true, false},
+		{"WITH d AS (DELETE FROM users RETURNING id) INSERT INTO log SELECT id FROM d", StatementTypeInsert, true, true, false},
+		{"UPDATE users SET a = 1", StatementTypeUpdate, true, false, false},
+		{"CREATE TABLE t AS SELECT * FROM users", StatementTypeCreateTable, true, false, false},
+		{"CREATE MATERIALIZED VIEW v AS SELECT 1", StatementTypeOther, true, false, false},
+		{"COMMENT ON COLUMN public.t.id IS 'key'", StatementTypeComment, true, false, false},
+		{"COMMENT ON FUNCTION f() IS 'x'", StatementTypeOther, true, false, false},
+		{"DROP TABLE users", StatementTypeOther, true, false, false},
+		{"SELECT 1; SELECT 2", "", false, false, true},
+		{"SELECT 1; DROP TABLE users", "", false, false, true},
+		{"CREATE TABLE t (id int); COMMENT ON TABLE t IS 'x'; COMMENT ON COLUMN t.id IS 'y'", StatementTypeCreateTable, true, false, false},
+		{"ALTER TABLE s.t ADD c int; COMMENT ON COLUMN s.t.c IS 'y'", StatementTypeAlterTable, true, false, false},
+		{"CREATE TABLE t (id int); COMMENT ON TABLE other IS 'x'", "", false, false, true},
+		{"CREATE TABLE s.t (id int); COMMENT ON TABLE t IS 'x'", "", false, false, true},
+		{"CREATE TABLE t (id int); DROP TABLE users", "", false, false, true},
+		{"COMMENT ON TABLE t IS 'x'; COMMENT ON TABLE t IS 'y'", "", false, false, true},
+		{"", "", false, false, true},
+		{"SELEC 1", "", false, false, true},
+	}
+	for _, tt := range tests {
+		stmt, err := ClassifyStatement(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ClassifyStatement(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if stmt.Type != tt.typ || stmt.ModifiesData != tt.modifies || stmt.ModifyingCTE != tt.modifyingCTE {
+			t.Errorf("ClassifyStatement(%q) = %+v, want type %s, modifies %v, modifying CTE %v", tt.query, stmt, tt.typ, tt.modifies, tt.modifyingCTE)
+		}
+	}
+}
+
+func TestValidateStatement(t *testing.T) {
+	tests := []struct {
+		query, expect string
+		wantErr       bool
+	}{
+		{"SELECT id FROM users", StatementTypeSelect, false},
+		{"SELECT * INTO copy FROM users", StatementTypeSelect, true},
+		{"SELECT * FROM users FOR UPDATE", StatementTypeSelect, true},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", StatementTypeSelect, true},
+		{"WITH d AS (DELETE FROM users RETURNING id) INSERT INTO log SELECT id FROM d", StatementTypeInsert, true},
+		{"INSERT INTO users (id) VALUES (1)", StatementTypeSelect, true},
+		{"UPDATE users SET a = 1", StatementTypeUpdate, true},
+		{"UPDATE users SET a = 1 WHERE id = 1", StatementTypeUpdate, false},
+		{"UPDATE users SET a = 1 FROM (SELECT 1 FROM x WHERE true) s", StatementTypeUpdate, true},
+		{"DELETE FROM users", StatementTypeDelete, true},
+		{"DELETE FROM users WHERE id IN (SELECT id FROM old)", StatementTypeDelete, false},
+		{"SELECT 1; SELECT 2", StatementTypeSelect, true},
+		{"CREATE TABLE t (id int); COMMENT ON COLUMN t.id IS 'key'", StatementTypeCreateTable, false},
+		{"CREATE TABLE t AS SELECT * FROM users", StatementTypeCreateTable, false},
+		{"CREATE TABLE t AS WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", StatementTypeCreateTable, true},
+		{"ALTER TABLE t ADD c int; COMMENT ON COLUMN t.c IS 'y'", StatementTypeAlterTable, false},
+		{"COMMENT ON COLUMN t.c IS 'y'", StatementTypeAlterTable, false},
+		{"COMMENT ON COLUMN t.c IS 'y'", StatementTypeCreateTable, true},
+		{"COMMENT ON SCHEMA public IS 'y'", StatementTypeAlterTable, true},
+		{"CREATE INDEX ON t (c)", StatementTypeCreateTable, true},
+	}
+	for _, tt := range tests {
+		if _, err := ValidateStatement(tt.query, tt.expect); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateStatement(%q, %s) error = %v, want error %v", tt.query, tt.expect, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckAccessComments(t *testing.T) {
+	withACLs(t, ACL{}, ACL{Deny: []string{"billing*", "public.users.ssn"}})
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"COMMENT ON TABLE public.orders IS 'x'", false},
+		{"COMMENT ON TABLE billing.invoices IS 'x'", true},
+		{"COMMENT ON COLUMN public.users.email IS 'x'", false},
+		{"COMMENT ON COLUMN public.users.ssn IS 'x'", true},
+		{"CREATE TABLE billing.notes AS SELECT 1", true},
+	}
+	for _, tt := range tests {
+		err := CheckAccess(context.Background(), tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckAccess(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
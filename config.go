@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// EnvPrefix prefixes the environment variables that override config settings,
+// e.g. POSTGRES_MCP_LIMITS_MAX_ROWS for limits.max_rows
+const EnvPrefix = "POSTGRES_MCP_"
+
+// configKeys maps config file settings to the flags they set. Lists are
+// joined with commas, durations are strings such as "30s".
+var configKeys = map[string]string{
+	"dsn":                        "dsn",
+	"dsn_file":                   "dsn-file",
+	"password_file":              "password-file",
+	"default_connection":         "default-connection",
+	"read_only":                  "read-only",
+	"lang":                       "lang",
+	"transport.type":             "t",
+	"transport.ip":               "ip",
+	"transport.port":             "port",
+	"transport.http_path":        "http-path",
+	"transport.http_stateful":    "http-stateful",
+	"transport.http_heartbeat":   "http-heartbeat-interval",
+	"transport.http_replay":      "http-replay-window",
+	"tls.cert_file":              "tls-cert",
+	"tls.key_file":               "tls-key",
+	"tls.client_ca_file":         "tls-client-ca",
+	"limits.query_timeout":       "query-timeout",
+	"limits.max_rows":            "max-rows",
+	"limits.cursor_idle_timeout": "cursor-idle-timeout",
+	"explain.enabled":            "with-explain-check",
+	"explain.max_seq_scan_rows":  "explain-max-seq-scan-rows",
+	"explain.max_cost":           "explain-max-cost",
+	"explain.max_rows":           "explain-max-rows",
+	"pool.max_open_conns":        "max-open-conns",
+	"pool.max_idle_conns":        "max-idle-conns",
+	"pool.conn_max_lifetime":     "conn-max-lifetime",
+	"pool.conn_max_idle_time":    "conn-max-idle-time",
+	"pool.health_check_interval": "health-check-interval",
+	"prompts.dir":                "prompts-dir",
+	"resources.refresh_interval": "resource-refresh-interval",
+	"databases.allow":            "allow-databases",
+	"databases.deny":             "deny-databases",
+	"acl.read.allow":             "acl-read-allow",
+	"acl.read.deny":              "acl-read-deny",
+	"acl.write.allow":            "acl-write-allow",
+	"acl.write.deny":             "acl-write-deny",
+	"acl.allow_functions":        "acl-allow-functions",
+	"masking.hash_key":           "mask-hash-key",
+	"audit.file":                 "audit-log",
+	"audit.table":                "audit-table",
+	"audit.connection":           "audit-connection",
+	"audit.redact_literals":      "audit-redact-literals",
+	"auth.jwks_file":             "auth-jwks",
+	"auth.issuer":                "auth-issuer",
+	"auth.audience":              "auth-audience",
+	"auth.identity_claim":        "auth-identity-claim",
+	"auth.read_write":            "auth-read-write",
+	"auth.write_scope":           "auth-write-scope",
+	"tools.enabled":              "enable-tools",
+	"tools.disabled":             "disable-tools",
+}
+
+// LoadConfig applies the config file at path and the POSTGRES_MCP_*
+// environment variables to every flag not given on the command line.
+// Command line flags win over the environment, which wins over the file.
+func LoadConfig(path string) error {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	settings := map[string]interface{}{}
+	var connectionTables map[string]interface{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+
+		if tables, ok := doc["connections"]; ok {
+			if connectionTables, ok = tables.(map[string]interface{}); !ok {
+				return fmt.Errorf("%s: connections must be a table of named connections", path)
+			}
+			delete(doc, "connections")
+		}
+
+		// [tools.<name>] tables customize single tools
+		if tools, ok := doc["tools"].(map[string]interface{}); ok {
+			for name, value := range tools {
+				if table, ok := value.(map[string]interface{}); ok {
+					if err := ParseToolSettings(name, table); err != nil {
+						return fmt.Errorf("%s: %v", path, err)
+					}
+					delete(tools, name)
+				}
+			}
+		}
+		// [masking.rules] maps patterns, which may contain dots, to strategies
+		if masking, ok := doc["masking"].(map[string]interface{}); ok {
+			if rules, ok := masking["rules"]; ok {
+				if err := loadMaskRules(rules); err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				delete(masking, "rules")
+			}
+		}
+		// [auth.tokens] maps identities to their static tokens
+		if auth, ok := doc["auth"].(map[string]interface{}); ok {
+			if tokens, ok := auth["tokens"]; ok {
+				if err := loadAuthTokens(tokens); err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				delete(auth, "tokens")
+			}
+		}
+		flattenConfig("", doc, settings)
+	}
+
+	for key, value := range settings {
+		name, ok := configKeys[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if explicit[name] {
+			continue
+		}
+		text, err := configValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+		if err := flag.Set(name, text); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+		}
+	}
+
+	for key, name := range configKeys {
+		env := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		value, ok := os.LookupEnv(env)
+		if !ok || explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", env, err)
+		}
+	}
+
+	return loadConnections(path, connectionTables)
+}
+
+// loadConnections registers the [connections.<name>] tables in name order
+func loadConnections(path string, tables map[string]interface{}) error {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table, ok := tables[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: connections.%s must be a table", path, name)
+		}
+		settings := map[string]string{}
+		for key, value := range table {
+			switch key {
+			case "dsn", "dsn_file", "password_file":
+				if settings[key], ok = value.(string); !ok {
+					return fmt.Errorf("%s: connections.%s.%s must be a string", path, name, key)
+				}
+			default:
+				return fmt.Errorf("%s: unknown setting \"connections.%s.%s\"", path, name, key)
+			}
+		}
+
+		dsn, err := resolveDSN(settings["dsn"], settings["dsn_file"])
+		if err != nil {
+			return fmt.Errorf("%s: connections.%s: %v", path, name, err)
+		}
+		if err := RegisterConnection(name, dsn, settings["password_file"]); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// loadMaskRules registers the pattern = "strategy" entries of [masking.rules]
+func loadMaskRules(value interface{}) error {
+	rules, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("masking.rules must be a table of pattern = \"strategy\" entries")
+	}
+	for pattern, value := range rules {
+		strategy, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("masking.rules: strategy of %q must be a string", pattern)
+		}
+		if err := AddMaskRule(pattern, strategy); err != nil {
+			return fmt.Errorf("masking.rules: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadAuthTokens registers the identity = "token" entries of [auth.tokens]
+func loadAuthTokens(value interface{}) error {
+	tokens, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("auth.tokens must be a table of identity = \"token\" entries")
+	}
+	for identity, value := range tokens {
+		token, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("auth.tokens: token of %q must be a string", identity)
+		}
+		if err := AddAuthToken(identity, token); err != nil {
+			return fmt.Errorf("auth.tokens: %v", err)
+		}
+	}
+	return nil
+}
+
+// resolveDSN returns dsn, or the contents of dsnFile when that is set
+func resolveDSN(dsn, dsnFile string) (string, error) {
+	if dsnFile == "" {
+		return dsn, nil
+	}
+	if dsn != "" {
+		return "", fmt.Errorf("dsn and dsn_file are mutually exclusive")
+	}
+	return ReadSecretFile(dsnFile)
+}
+
+// flattenConfig turns nested tables into dotted keys
+func flattenConfig(prefix string, table map[string]interface{}, out map[string]interface{}) {
+	for key, value := range table {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenConfig(prefix+key+".", nested, out)
+			continue
+		}
+		out[prefix+key] = value
+	}
+}
+
+// configValue renders a decoded TOML value the way the flag expects it
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// ValidateSettings reports invalid settings once flags, environment and the
+// config file have been applied
+func ValidateSettings() error {
+	if MaxRows <= 0 {
+		return fmt.Errorf("--max-rows must be positive")
+	}
+	if ResourceRefreshInterval < 0 {
+		return fmt.Errorf("--resource-refresh-interval must not be negative")
+	}
+	if QueryTimeout < 0 || CursorIdleTimeout <= 0 {
+		return fmt.Errorf("--query-timeout must not be negative and --cursor-idle-timeout must be positive")
+	}
+	if ExplainMaxSeqScanRows < 0 || ExplainMaxCost < 0 || ExplainMaxRows < 0 {
+		return fmt.Errorf("explain limits must not be negative")
+	}
+	switch Transport {
+	case TransportStdio, TransportSSE, TransportHTTP:
+	default:
+		return fmt.Errorf("unsupported transport %q (use stdio, sse or http)", Transport)
+	}
+	if !strings.HasPrefix(HTTPPath, "/") {
+		return fmt.Errorf("--http-path must start with /")
+	}
+	if (TLSCertFile == "") != (TLSKeyFile == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if TLSClientCAFile != "" && TLSCertFile == "" {
+		return fmt.Errorf("--tls-client-ca needs --tls-cert and --tls-key")
+	}
+	if TLSCertFile != "" && Transport == TransportStdio {
+		return fmt.Errorf("--tls-cert needs the sse or http transport")
+	}
+	if AuthJWKSFile != "" && AuthAudience == "" {
+		return fmt.Errorf("--auth-jwks needs --auth-audience, the URI tokens must be issued for")
+	}
+	if HTTPHeartbeatInterval < 0 {
+		return fmt.Errorf("--http-heartbeat-interval must not be negative")
+	}
+	if HTTPReplayWindow < 0 {
+		return fmt.Errorf("--http-replay-window must not be negative")
+	}
+	if Port <= 0 || Port > 65535 {
+		return fmt.Errorf("invalid port %d", Port)
+	}
+	return ValidatePoolConfig()
+}
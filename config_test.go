@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags are the flags withFlags defines in place of those of main
+type testFlags struct {
+	transport    string
+	port         int
+	maxRows      int
+	queryTimeout time.Duration
+}
+
+// withFlags replaces the command line flags with a few of those config keys
+// set, parsed from args, for the duration of a test
+func withFlags(t *testing.T, args ...string) *testFlags {
+	t.Helper()
+	old := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = old })
+
+	flags := &testFlags{}
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	flag.StringVar(&flags.transport, "t", "stdio", "")
+	flag.IntVar(&flags.port, "port", 8080, "")
+	flag.IntVar(&flags.maxRows, "max-rows", 1000, "")
+	flag.DurationVar(&flags.queryTimeout, "query-timeout", 0, "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	const file = `
+[transport]
+type = "sse"
+port = 9000
+
+[limits]
+max_rows = 50
+query_timeout = "30s"
+`
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string
+		args    []string
+		want    testFlags
+		wantErr string
+	}{
+		{
+			name:   "file",
+			config: file,
+			want:   testFlags{transport: "sse", port: 9000, maxRows: 50, queryTimeout: 30 * time.Second},
+		},
+		{
+			name:   "environment over file",
+			config: file,
+			env:    map[string]string{"POSTGRES_MCP_TRANSPORT_PORT": "9100", "POSTGRES_MCP_LIMITS_QUERY_TIMEOUT": "1m"},
+			want:   testFlags{transport: "sse", port: 9100, maxRows: 50, queryTimeout: time.Minute},
+		},
+		{
+			name:   "flag over environment and file",
+			config: file,
+			env:    map[string]string{"POSTGRES_MCP_TRANSPORT_PORT": "9100"},
+			args:   []string{"-port", "9200", "-max-rows", "10"},
+			want:   testFlags{transport: "sse", port: 9200, maxRows: 10, queryTimeout: 30 * time.Second},
+		},
+		{
+			name: "environment without file",
+			env:  map[string]string{"POSTGRES_MCP_LIMITS_MAX_ROWS": "75"},
+			want: testFlags{transport: "stdio", port: 8080, maxRows: 75},
+		},
+		{
+			name:    "unknown key",
+			config:  "[limits]\nmax_row = 5\n",
+			wantErr: `unknown setting "limits.max_row"`,
+		},
+		{
+			name:    "unknown top level key",
+			config:  "port = 5\n",
+			wantErr: `unknown setting "port"`,
+		},
+		{
+			name:    "unknown connection key",
+			config:  "[connections.main]\nhost = \"db\"\n",
+			wantErr: `unknown setting "connections.main.host"`,
+		},
+		{
+			name:    "invalid value",
+			config:  "[transport]\nport = \"high\"\n",
+			wantErr: "invalid value for transport.port",
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"POSTGRES_MCP_LIMITS_QUERY_TIMEOUT": "soon"},
+			wantErr: "invalid value for POSTGRES_MCP_LIMITS_QUERY_TIMEOUT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := withFlags(t, tt.args...)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if tt.config != "" {
+				path = writeConfig(t, tt.config)
+			}
+
+			err := LoadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if *flags != tt.want {
+				t.Errorf("flags = %+v, want %+v", *flags, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// DefaultConnectionName names the connection given by --dsn
+const DefaultConnectionName = "default"
+
+// Connection is a named database server the server can query. Its pool is
+// opened on first use, as are pools for sibling databases on the same server.
+type Connection struct {
+	Name   string
+	config *pgx.ConnConfig
+
+	mu       sync.Mutex
+	db       *sqlx.DB
+	siblings map[string]*sqlx.DB
+}
+
+var (
+	connections       = map[string]*Connection{}
+	DefaultConnection string
+	// Glob patterns of databases reachable through the database argument
+	AllowedDatabases []string
+	DeniedDatabases  []string
+)
+
+type (
+	connectionKey struct{}
+	databaseKey   struct{}
+)
+
+// ConnectionFlag collects repeated --connection name=dsn flags
+type ConnectionFlag []string
+
+func (f *ConnectionFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *ConnectionFlag) Set(value string) error {
+	name, dsn, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=dsn, got %q", value)
+	}
+	*f = append(*f, value)
+	return RegisterConnection(strings.TrimSpace(name), dsn, "")
+}
+
+// RegisterConnection adds a named connection. Settings missing from dsn come
+// from the PG* environment variables, the service file and ~/.pgpass like in
+// libpq; a non-empty passwordFile overrides the password. The first
+// connection registered becomes the default for tool calls without a
+// connection argument.
+func RegisterConnection(name, dsn, passwordFile string) error {
+	if _, ok := connections[name]; ok {
+		return fmt.Errorf("connection %q is defined twice", name)
+	}
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse DSN of connection %q: %v", name, err)
+	}
+	if passwordFile != "" {
+		if config.Password, err = ReadSecretFile(passwordFile); err != nil {
+			return fmt.Errorf("connection %q: %v", name, err)
+		}
+	}
+	AddSecret(config.Password)
+
+	connections[name] = &Connection{Name: name, config: config}
+	if DefaultConnection == "" {
+		DefaultConnection = name
+	}
+	return nil
+}
+
+// ConnectionNames returns the registered connection names in sorted order
+func ConnectionNames() []string {
+	names := make([]string, 0, len(connections))
+	for name := range connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConnectionMiddleware routes a tool call to the database named by its
+// connection and database arguments
+func ConnectionMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if name := getStringParam(request, "connection", ""); name != "" {
+			ctx = context.WithValue(ctx, connectionKey{}, name)
+		}
+		if database := getStringParam(request, "database", ""); database != "" {
+			ctx = context.WithValue(ctx, databaseKey{}, database)
+		}
+		return next(ctx, request)
+	}
+}
+
+// ConnectionFromContext returns the connection selected for the current call
+func ConnectionFromContext(ctx context.Context) (*Connection, error) {
+	name, _ := ctx.Value(connectionKey{}).(string)
+	if name == "" {
+		name = DefaultConnection
+	}
+
+	conn, ok := connections[name]
+	if !ok {
+		return nil, Errorf("err_unknown_connection", "unknown connection %q (available: %s)", name, strings.Join(ConnectionNames(), ", "))
+	}
+	return conn, nil
+}
+
+// GetDB returns the pool of the connection and database selected for the current call
+func GetDB(ctx context.Context) (*sqlx.DB, error) {
+	conn, err := ConnectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if database, _ := ctx.Value(databaseKey{}).(string); database != "" {
+		return conn.Database(database)
+	}
+	return conn.DB()
+}
+
+// DB opens the pool of the database named in the DSN on first use
+func (c *Connection) DB() (*sqlx.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db != nil {
+		return c.db, nil
+	}
+
+	db, err := c.open(c.config.Database)
+	if err != nil {
+		return nil, err
+	}
+	c.db = db
+	return c.db, nil
+}
+
+// Database returns a pool for another database on the same server, opened
+// with the connection's credentials on first use
+func (c *Connection) Database(name string) (*sqlx.DB, error) {
+	if name == c.config.Database {
+		return c.DB()
+	}
+	if !DatabaseAllowed(name) {
+		return nil, Errorf("err_database_denied", "database %q may not be accessed", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if db, ok := c.siblings[name]; ok {
+		return db, nil
+	}
+
+	db, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.siblings == nil {
+		c.siblings = map[string]*sqlx.DB{}
+	}
+	c.siblings[name] = db
+	return db, nil
+}
+
+func (c *Connection) open(database string) (*sqlx.DB, error) {
+	config := c.config.Copy()
+	config.Database = database
+	// In read-only mode every session refuses writes, whatever the tool sends
+	if ReadOnly {
+		config.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+
+	db := sqlx.NewDb(stdlib.OpenDB(*config), "pgx")
+	ConfigurePool(db)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, Errorf("err_connect", "failed to establish database connection %q: %v", c.Name, err)
+	}
+
+	go MonitorHealth(db)
+	return db, nil
+}
+
+// DatabaseAllowed reports whether the database argument may name database.
+// Denied patterns win over allowed ones; no allowed patterns allows every
+// database that is not denied.
+func DatabaseAllowed(database string) bool {
+	for _, pattern := range DeniedDatabases {
+		if ok, _ := path.Match(pattern, database); ok {
+			return false
+		}
+	}
+	if len(AllowedDatabases) == 0 {
+		return true
+	}
+	for _, pattern := range AllowedDatabases {
+		if ok, _ := path.Match(pattern, database); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePatternList splits a comma separated flag value into glob patterns
+func ParsePatternList(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// ListConnections describes every configured connection without credentials
+func ListConnections() ([]map[string]interface{}, []Column) {
+	columns := []Column{
+		{Name: "name", Type: "text"},
+		{Name: "host", Type: "text"},
+		{Name: "port", Type: "int4"},
+		{Name: "database", Type: "text"},
+		{Name: "user", Type: "text"},
+		{Name: "default", Type: "bool"},
+		{Name: "connected", Type: "bool"},
+	}
+
+	var rows []map[string]interface{}
+	for _, name := range ConnectionNames() {
+		conn := connections[name]
+		conn.mu.Lock()
+		connected := conn.db != nil
+		conn.mu.Unlock()
+
+		rows = append(rows, map[string]interface{}{
+			"name":      name,
+			"host":      conn.config.Host,
+			"port":      int64(conn.config.Port),
+			"database":  conn.config.Database,
+			"user":      conn.config.User,
+			"default":   name == DefaultConnection,
+			"connected": connected,
+		})
+	}
+	return rows, columns
+}
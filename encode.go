@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// Column is a result column as described by the row description
+type Column struct {
+	Name     string
+	Type     string // PostgreSQL type name, e.g. int4, numeric, _uuid
+	OID      uint32
+	Nullable bool // False only for table columns declared NOT NULL
+	// Table column the values are read from, empty for computed columns
+	Schema string
+	Table  string
+	Source string
+}
+
+// ColumnNames returns the names of columns in result order
+func ColumnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// QueryTyped runs query on the pgx connection behind conn. Every column is
+// requested in text format and decoded by DecodeValue, so values keep their
+// canonical PostgreSQL rendering instead of going through database/sql.
+func QueryTyped(ctx context.Context, conn *sqlx.Conn, query string, args ...interface{}) ([]map[string]interface{}, []Column, error) {
+	var result []map[string]interface{}
+	var columns []Column
+
+	err := conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		typeMap := pgxConn.TypeMap()
+
+		queryArgs := append([]any{pgx.QueryResultFormats{pgx.TextFormatCode}}, args...)
+		rows, err := pgxConn.Query(ctx, query, queryArgs...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		fields := rows.FieldDescriptions()
+		for _, fd := range fields {
+			columns = append(columns, Column{Name: fd.Name, OID: fd.DataTypeOID, Nullable: true})
+		}
+
+		for rows.Next() {
+			row := map[string]interface{}{}
+			for i, raw := range rows.RawValues() {
+				value, err := DecodeValue(typeMap, columns[i].OID, raw)
+				if err != nil {
+					return fmt.Errorf("failed to decode column %s: %v", columns[i].Name, err)
+				}
+				row[columns[i].Name] = value
+			}
+			result = append(result, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(columns) > 0 {
+			return describeColumns(ctx, pgxConn, columns, fields)
+		}
+		return nil
+	})
+
+	return result, columns, err
+}
+
+// describeColumns fills in type names, including types pgx does not know
+// about such as hstore, and the origin and NOT NULL constraints of columns
+// read directly from a table
+func describeColumns(ctx context.Context, conn *pgx.Conn, columns []Column, fields []pgconn.FieldDescription) error {
+	typeOIDs := make([]uint32, len(fields))
+	tableOIDs := make([]uint32, len(fields))
+	attNums := make([]int16, len(fields))
+	for i, fd := range fields {
+		typeOIDs[i] = fd.DataTypeOID
+		tableOIDs[i] = fd.TableOID
+		attNums[i] = int16(fd.TableAttributeNumber)
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT c.idx, t.typname, COALESCE(a.attnotnull, false),
+			COALESCE(n.nspname, ''), COALESCE(r.relname, ''), COALESCE(a.attname, '')
+		FROM unnest($1::oid[], $2::oid[], $3::int2[]) WITH ORDINALITY AS c(typid, relid, attnum, idx)
+		JOIN pg_type t ON t.oid = c.typid
+		LEFT JOIN pg_attribute a ON a.attrelid = c.relid AND a.attnum = c.attnum
+		LEFT JOIN pg_class r ON r.oid = a.attrelid
+		LEFT JOIN pg_namespace n ON n.oid = r.relnamespace`,
+		typeOIDs, tableOIDs, attNums)
+	if err != nil {
+		return err
+	}
+
+	var idx int64
+	var name, schema, table, source string
+	var notNull bool
+	_, err = pgx.ForEachRow(rows, []any{&idx, &name, &notNull, &schema, &table, &source}, func() error {
+		col := &columns[idx-1]
+		col.Type = name
+		col.Nullable = !notNull
+		col.Schema, col.Table, col.Source = schema, table, source
+		return nil
+	})
+	return err
+}
+
+// DecodeValue turns the text representation of a value of the given type OID
+// into a Go value that the output formats render faithfully:
+//   - integers become int64; int8, floats and numeric become json.Number so no
+//     precision is lost
+//   - json/jsonb become json.RawMessage and are embedded as-is
+//   - timestamps become RFC 3339 strings
+//   - arrays become nested []interface{} of decoded elements
+//   - everything else (bytea as \x hex, interval, uuid, inet, ranges, hstore, ...)
+//     keeps PostgreSQL's own text output
+func DecodeValue(m *pgtype.Map, oid uint32, raw []byte) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	text := string(raw)
+	switch oid {
+	case pgtype.BoolOID:
+		return text == "t", nil
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.OIDOID:
+		return strconv.ParseInt(text, 10, 64)
+	case pgtype.Int8OID:
+		return json.Number(text), nil
+	case pgtype.Float4OID, pgtype.Float8OID, pgtype.NumericOID:
+		// NaN and Infinity are not valid JSON numbers
+		switch text {
+		case "NaN", "Infinity", "-Infinity":
+			return text, nil
+		}
+		return json.Number(text), nil
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		return json.RawMessage(text), nil
+	case pgtype.TimestamptzOID:
+		var ts pgtype.Timestamptz
+		if err := m.Scan(oid, pgtype.TextFormatCode, raw, &ts); err != nil || ts.InfinityModifier != pgtype.Finite {
+			return text, nil
+		}
+		return ts.Time.Format(time.RFC3339Nano), nil
+	case pgtype.TimestampOID:
+		var ts pgtype.Timestamp
+		if err := m.Scan(oid, pgtype.TextFormatCode, raw, &ts); err != nil || ts.InfinityModifier != pgtype.Finite {
+			return text, nil
+		}
+		return ts.Time.Format("2006-01-02T15:04:05.999999999"), nil
+	}
+
+	if dt, ok := m.TypeForOID(oid); ok {
+		if codec, ok := dt.Codec.(*pgtype.ArrayCodec); ok {
+			return decodeArray(m, oid, codec.ElementType.OID, raw)
+		}
+	}
+
+	return text, nil
+}
+
+// decodeArray decodes a (possibly multi-dimensional) array into nested slices
+func decodeArray(m *pgtype.Map, oid, elementOID uint32, raw []byte) (interface{}, error) {
+	var arr pgtype.Array[*string]
+	if err := m.Scan(oid, pgtype.TextFormatCode, raw, &arr); err != nil {
+		return nil, err
+	}
+
+	elements := make([]interface{}, len(arr.Elements))
+	for i, element := range arr.Elements {
+		if element == nil {
+			continue
+		}
+		value, err := DecodeValue(m, elementOID, []byte(*element))
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = value
+	}
+
+	if len(arr.Dims) == 0 {
+		return []interface{}{}, nil
+	}
+	return nestArray(elements, arr.Dims), nil
+}
+
+// nestArray splits flat row-major elements into one slice level per dimension
+func nestArray(elements []interface{}, dims []pgtype.ArrayDimension) []interface{} {
+	if len(dims) == 1 {
+		return elements
+	}
+
+	size := len(elements) / int(dims[0].Length)
+	nested := make([]interface{}, dims[0].Length)
+	for i := range nested {
+		nested[i] = nestArray(elements[i*size:(i+1)*size], dims[1:])
+	}
+	return nested
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestDecodeValue(t *testing.T) {
+	m := pgtype.NewMap()
+	tests := []struct {
+		name string
+		oid  uint32
+		raw  []byte
+		want interface{}
+	}{
+		{"null", pgtype.Int4OID, nil, nil},
+		{"true", pgtype.BoolOID, []byte("t"), true},
+		{"false", pgtype.BoolOID, []byte("f"), false},
+		{"int4", pgtype.Int4OID, []byte("-42"), int64(-42)},
+		{"int8 beyond float precision", pgtype.Int8OID, []byte("9007199254740993"), json.Number("9007199254740993")},
+		{"numeric keeps its scale", pgtype.NumericOID, []byte("1.50"), json.Number("1.50")},
+		{"float NaN", pgtype.Float8OID, []byte("NaN"), "NaN"},
+		{"float infinity", pgtype.Float4OID, []byte("-Infinity"), "-Infinity"},
+		{"jsonb", pgtype.JSONBOID, []byte(`{"a": [1, 2]}`), json.RawMessage(`{"a": [1, 2]}`)},
+		{"timestamptz", pgtype.TimestamptzOID, []byte("2024-05-06 07:08:09.5+00"), "2024-05-06T07:08:09.5Z"},
+		{"timestamptz infinity", pgtype.TimestamptzOID, []byte("infinity"), "infinity"},
+		{"timestamp", pgtype.TimestampOID, []byte("2024-05-06 07:08:09"), "2024-05-06T07:08:09"},
+		{"bytea", pgtype.ByteaOID, []byte(`\xdeadbeef`), `\xdeadbeef`},
+		{"uuid", pgtype.UUIDOID, []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{"unknown type", 999999, []byte("a=>1"), "a=>1"},
+		{"int4 array", pgtype.Int4ArrayOID, []byte("{1,NULL,3}"), []interface{}{int64(1), nil, int64(3)}},
+		{"text array", pgtype.TextArrayOID, []byte(`{a,"b,c"}`), []interface{}{"a", "b,c"}},
+		{"empty array", pgtype.TextArrayOID, []byte("{}"), []interface{}{}},
+		{"two-dimensional array", pgtype.Int4ArrayOID, []byte("{{1,2},{3,4}}"), []interface{}{
+			[]interface{}{int64(1), int64(2)},
+			[]interface{}{int64(3), int64(4)},
+		}},
+		{"numeric array", pgtype.NumericArrayOID, []byte("{1.0,NaN}"), []interface{}{json.Number("1.0"), "NaN"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeValue(m, tt.oid, tt.raw)
+			if err != nil {
+				t.Fatalf("DecodeValue: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeValue = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestArray(t *testing.T) {
+	elements := func(n int) []interface{} {
+		e := make([]interface{}, n)
+		for i := range e {
+			e[i] = i + 1
+		}
+		return e
+	}
+	tests := []struct {
+		name     string
+		elements []interface{}
+		dims     []int32
+		want     []interface{}
+	}{
+		{"one dimension", elements(3), []int32{3}, []interface{}{1, 2, 3}},
+		{"rows of three", elements(6), []int32{2, 3}, []interface{}{
+			[]interface{}{1, 2, 3},
+			[]interface{}{4, 5, 6},
+		}},
+		{"three dimensions", elements(8), []int32{2, 2, 2}, []interface{}{
+			[]interface{}{[]interface{}{1, 2}, []interface{}{3, 4}},
+			[]interface{}{[]interface{}{5, 6}, []interface{}{7, 8}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dims := make([]pgtype.ArrayDimension, len(tt.dims))
+			for i, length := range tt.dims {
+				dims[i] = pgtype.ArrayDimension{Length: length, LowerBound: 1}
+			}
+			if got := nestArray(tt.elements, dims); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nestArray = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
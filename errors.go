@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// QueryError is a PostgreSQL error raised by a statement, kept together with
+// the query so the failing position can be pointed out
+type QueryError struct {
+	Query  string
+	Offset int // Characters sent ahead of Query, e.g. an EXPLAIN or DECLARE prefix
+	Err    *pgconn.PgError
+}
+
+// QueryErrorInfo is the structured content attached to failed tool results
+type QueryErrorInfo struct {
+	Code           string `json:"code"`
+	Severity       string `json:"severity"`
+	Message        string `json:"message"`
+	Detail         string `json:"detail,omitempty"`
+	Hint           string `json:"hint,omitempty"`
+	Position       int    `json:"position,omitempty"`
+	Where          string `json:"where,omitempty"`
+	SchemaName     string `json:"schema,omitempty"`
+	TableName      string `json:"table,omitempty"`
+	ColumnName     string `json:"column,omitempty"`
+	DataTypeName   string `json:"data_type,omitempty"`
+	ConstraintName string `json:"constraint,omitempty"`
+}
+
+// WrapQueryError attaches query to err when it carries a PostgreSQL error.
+// offset is the number of characters that preceded query in the statement
+// actually sent to the server.
+func WrapQueryError(err error, query string, offset int) error {
+	var qerr *QueryError
+	if err == nil || errors.As(err, &qerr) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	return MaskQueryError(&QueryError{Query: query, Offset: offset, Err: pgErr}, query)
+}
+
+// Error renders the error the way psql does, with the offending line of the
+// query and a caret under the reported position
+func (e *QueryError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s (SQLSTATE %s)", e.Err.Severity, e.Err.Message, e.Err.Code)
+
+	if pos := e.position(); pos > 0 {
+		b.WriteString("\n" + caretLines(e.Query, pos, "LINE"))
+	}
+	if e.Err.InternalQuery != "" && e.Err.InternalPosition > 0 {
+		b.WriteString("\n" + caretLines(e.Err.InternalQuery, int(e.Err.InternalPosition), "QUERY"))
+	}
+
+	fields := []struct{ label, value string }{
+		{"DETAIL", e.Err.Detail},
+		{"HINT", e.Err.Hint},
+		{"CONTEXT", e.Err.Where},
+		{"SCHEMA", e.Err.SchemaName},
+		{"TABLE", e.Err.TableName},
+		{"COLUMN", e.Err.ColumnName},
+		{"DATA TYPE", e.Err.DataTypeName},
+		{"CONSTRAINT", e.Err.ConstraintName},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Fprintf(&b, "\n%s: %s", f.label, f.value)
+		}
+	}
+	return b.String()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Info returns the error fields as structured content
+func (e *QueryError) Info() *QueryErrorInfo {
+	return &QueryErrorInfo{
+		Code:           e.Err.Code,
+		Severity:       e.Err.Severity,
+		Message:        e.Err.Message,
+		Detail:         e.Err.Detail,
+		Hint:           e.Err.Hint,
+		Position:       e.position(),
+		Where:          e.Err.Where,
+		SchemaName:     e.Err.SchemaName,
+		TableName:      e.Err.TableName,
+		ColumnName:     e.Err.ColumnName,
+		DataTypeName:   e.Err.DataTypeName,
+		ConstraintName: e.Err.ConstraintName,
+	}
+}
+
+// position is the 1-based character position within Query, or 0 if the
+// server reported none or it points into the prefix
+func (e *QueryError) position() int {
+	pos := int(e.Err.Position) - e.Offset
+	if pos < 1 || pos > len([]rune(e.Query))+1 {
+		return 0
+	}
+	return pos
+}
+
+// caretLines renders the line of query holding the 1-based character position
+// pos followed by a caret under that character, e.g.
+//
+//	LINE 1: SELECT nme FROM users
+//	               ^
+func caretLines(query string, pos int, label string) string {
+	runes := []rune(query)
+	if pos > len(runes)+1 {
+		pos = len(runes) + 1
+	}
+
+	start, lineNo := 0, 1
+	for i := 0; i < pos-1; i++ {
+		if runes[i] == '\n' {
+			start, lineNo = i+1, lineNo+1
+		}
+	}
+	end := start
+	for end < len(runes) && runes[end] != '\n' {
+		end++
+	}
+
+	prefix := fmt.Sprintf("%s %d: ", label, lineNo)
+	if label != "LINE" {
+		prefix = label + ": "
+	}
+	line := strings.TrimRight(string(runes[start:end]), "\r")
+
+	// Keep tabs in the indent so the caret lines up however tabs are displayed
+	indent := []rune(strings.Repeat(" ", len(prefix)))
+	for _, r := range runes[start : pos-1] {
+		if r == '\t' {
+			indent = append(indent, '\t')
+		} else {
+			indent = append(indent, ' ')
+		}
+	}
+	return prefix + line + "\n" + string(indent) + "^"
+}
+
+// sqlStateClasses names the SQLSTATE classes by their first two characters
+var sqlStateClasses = map[string]string{
+	"08": "connection exception",
+	"0A": "feature not supported",
+	"21": "cardinality violation",
+	"22": "data exception",
+	"23": "integrity constraint violation",
+	"25": "invalid transaction state",
+	"28": "invalid authorization specification",
+	"2B": "dependent privilege descriptors still exist",
+	"34": "invalid cursor name",
+	"40": "transaction rollback",
+	"42": "syntax error or access rule violation",
+	"53": "insufficient resources",
+	"54": "program limit exceeded",
+	"55": "object not in prerequisite state",
+	"57": "operator intervention",
+	"58": "system error",
+	"P0": "PL/pgSQL error",
+	"XX": "internal error",
+}
+
+// SQLStateClass returns the name of the class of a SQLSTATE code, a message
+// that cannot quote any value
+func SQLStateClass(code string) string {
+	if len(code) >= 2 {
+		if class, ok := sqlStateClasses[code[:2]]; ok {
+			return class
+		}
+	}
+	return "error"
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCaretLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		pos   int
+		label string
+		want  string
+	}{
+		{"first character", "SELECT nme FROM users", 1, "LINE",
+			"LINE 1: SELECT nme FROM users\n        ^"},
+		{"single line", "SELECT nme FROM users", 8, "LINE",
+			"LINE 1: SELECT nme FROM users\n               ^"},
+		{"second line", "SELECT id\nFROM usrs", 16, "LINE",
+			"LINE 2: FROM usrs\n             ^"},
+		{"crlf line endings", "SELECT id\r\nFROM usrs\r\nWHERE", 17, "LINE",
+			"LINE 2: FROM usrs\n             ^"},
+		{"tabs keep their width", "SELECT\n\tid,\tnme", 13, "LINE",
+			"LINE 2: \tid,\tnme\n        \t   \t^"},
+		{"multibyte characters count once", "SELECT 'äöü' + x", 16, "LINE",
+			"LINE 1: SELECT 'äöü' + x\n                       ^"},
+		{"position past the end", "SELECT 1 +", 42, "LINE",
+			"LINE 1: SELECT 1 +\n                  ^"},
+		{"other label", "SELECT bad()", 8, "QUERY",
+			"QUERY: SELECT bad()\n              ^"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := caretLines(tt.query, tt.pos, tt.label); got != tt.want {
+				t.Errorf("caretLines(%q, %d, %s) =\n%s\nwant\n%s", tt.query, tt.pos, tt.label, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ExplainPlan is a node of EXPLAIN (FORMAT JSON) output
+type ExplainPlan struct {
+	NodeType     string        `json:"Node Type"`
+	Operation    string        `json:"Operation"`
+	RelationName string        `json:"Relation Name"`
+	Schema       string        `json:"Schema"`
+	TotalCost    float64       `json:"Total Cost"`
+	PlanRows     float64       `json:"Plan Rows"`
+	PlanWidth    float64       `json:"Plan Width"`
+	Plans        []ExplainPlan `json:"Plans"`
+}
+
+// ParseExplainJSON decodes the single-row result of EXPLAIN (FORMAT JSON)
+func ParseExplainJSON(data []byte) (*ExplainPlan, error) {
+	var out []struct {
+		Plan ExplainPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, Errorf("err_explain_output", "failed to parse EXPLAIN output: %v", err)
+	}
+	if len(out) == 0 {
+		return nil, Errorf("err_explain_no_plan", "EXPLAIN returned no plan")
+	}
+	return &out[0].Plan, nil
+}
+
+type seqScansAllowedKey struct{}
+
+// AllowSeqScans exempts the queries run with the returned context from
+// --explain-max-seq-scan-rows; the cost and row limits still apply
+func AllowSeqScans(ctx context.Context) context.Context {
+	return context.WithValue(ctx, seqScansAllowedKey{}, true)
+}
+
+// CheckExplainPlan applies the --explain-* policies to plan and returns an
+// error naming every node that violated a rule
+func CheckExplainPlan(ctx context.Context, db *sqlx.DB, plan *ExplainPlan, expect string) error {
+	var violations []string
+
+	if v := checkPlanType(plan, expect); v != "" {
+		violations = append(violations, v)
+	}
+
+	if ExplainMaxCost > 0 && plan.TotalCost > ExplainMaxCost {
+		violations = append(violations, Tf("plan_cost_exceeded", "%s: estimated total cost %.0f exceeds the limit of %.0f", plan.describe(), plan.TotalCost, ExplainMaxCost))
+	}
+
+	if ExplainMaxRows > 0 && plan.PlanRows > ExplainMaxRows {
+		violations = append(violations, Tf("plan_rows_exceeded", "%s: estimated %.0f rows exceeds the limit of %.0f", plan.describe(), plan.PlanRows, ExplainMaxRows))
+	}
+
+	if ExplainMaxSeqScanRows > 0 && ctx.Value(seqScansAllowedKey{}) == nil {
+		seqScans, err := checkSeqScans(ctx, db, plan)
+		if err != nil {
+			return err
+		}
+		violations = append(violations, seqScans...)
+	}
+
+	if len(violations) > 0 {
+		return Errorf("err_plan_rejected", "query plan rejected by explain check:\n- %s", strings.Join(violations, "\n- "))
+	}
+	return nil
+}
+
+// checkPlanType verifies the top plan node matches the classified statement
+func checkPlanType(plan *ExplainPlan, expect string) string {
+	switch expect {
+	case StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete:
+		if plan.NodeType != "ModifyTable" || !strings.EqualFold(plan.Operation, expect) {
+			return Tf("plan_modify_table", "%s: top plan node should be a ModifyTable %s for a %s statement", plan.describe(), expect, expect)
+		}
+	case StatementTypeSelect:
+		if plan.NodeType == "ModifyTable" {
+			return Tf("plan_select_modifies", "%s: SELECT statements must not plan a ModifyTable node", plan.describe())
+		}
+	}
+	return ""
+}
+
+// seqScanRowsQuery estimates the rows of a table from its planner statistics.
+// Tables that were never analyzed have reltuples -1; their rows are estimated
+// from the size on disk and the width of the scanned tuples (plus page and
+// tuple headers), as the planner does.
+const seqScanRowsQuery = `
+	SELECT CASE WHEN c.reltuples >= 0 THEN c.reltuples::float8
+		ELSE pg_relation_size(c.oid) / b.size * floor((b.size - 24) / ($2::float8 + 28))
+	END
+	FROM pg_class c, (SELECT current_setting('block_size')::float8 AS size) b
+	WHERE c.oid = $1::regclass`
+
+// checkSeqScans walks the plan and reports sequential scans over tables whose
+// estimated rows exceed --explain-max-seq-scan-rows
+func checkSeqScans(ctx context.Context, db *sqlx.DB, plan *ExplainPlan) ([]string, error) {
+	var violations []string
+
+	if plan.NodeType == "Seq Scan" && plan.RelationName != "" {
+		var tableRows float64
+		err := db.GetContext(ctx, &tableRows, seqScanRowsQuery, QuoteIdentifier(plan.Schema, plan.RelationName), plan.PlanWidth)
+		if err != nil {
+			return nil, Errorf("err_row_estimate", "failed to look up row estimate for %s: %v", plan.RelationName, err)
+		}
+		// The planner scales stale statistics to the current table size
+		tableRows = max(tableRows, plan.PlanRows)
+		if tableRows > ExplainMaxSeqScanRows {
+			violations = append(violations, Tf("plan_seq_scan", "%s: sequential scan over ~%.0f rows exceeds the limit of %.0f; filter on an indexed column or add an index", plan.describe(), tableRows, ExplainMaxSeqScanRows))
+		}
+	}
+
+	for i := range plan.Plans {
+		v, err := checkSeqScans(ctx, db, &plan.Plans[i])
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	return violations, nil
+}
+
+func (p *ExplainPlan) describe() string {
+	if p.RelationName == "" {
+		return p.NodeType
+	}
+	if p.Schema == "" {
+		return Tf("plan_node", "%s on %s", p.NodeType, p.RelationName)
+	}
+	return Tf("plan_node", "%s on %s", p.NodeType, p.Schema+"."+p.RelationName)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckPlanType(t *testing.T) {
+	tests := []struct {
+		plan   ExplainPlan
+		expect string
+		want   bool
+	}{
+		{ExplainPlan{NodeType: "ModifyTable", Operation: "Update"}, StatementTypeUpdate, true},
+		{ExplainPlan{NodeType: "ModifyTable", Operation: "Delete"}, StatementTypeUpdate, false},
+		{ExplainPlan{NodeType: "Seq Scan"}, StatementTypeInsert, false},
+		{ExplainPlan{NodeType: "Aggregate"}, StatementTypeSelect, true},
+		{ExplainPlan{NodeType: "ModifyTable", Operation: "Insert"}, StatementTypeSelect, false},
+	}
+	for _, tt := range tests {
+		if got := checkPlanType(&tt.plan, tt.expect) == ""; got != tt.want {
+			t.Errorf("checkPlanType(%s %s, %s) passed = %v, want %v", tt.plan.NodeType, tt.plan.Operation, tt.expect, got, tt.want)
+		}
+	}
+}
+
+func TestCheckExplainPlanAllowSeqScans(t *testing.T) {
+	oldCost, oldSeqScan := ExplainMaxCost, ExplainMaxSeqScanRows
+	t.Cleanup(func() { ExplainMaxCost, ExplainMaxSeqScanRows = oldCost, oldSeqScan })
+	ExplainMaxCost, ExplainMaxSeqScanRows = 1000, 1
+
+	// The seq scan rule needs a database; exempt queries never consult it
+	plan := &ExplainPlan{NodeType: "Aggregate", TotalCost: 10, PlanRows: 1,
+		Plans: []ExplainPlan{{NodeType: "Seq Scan", RelationName: "events", Schema: "public", PlanRows: 1e9}}}
+	if err := CheckExplainPlan(AllowSeqScans(context.Background()), nil, plan, StatementTypeSelect); err != nil {
+		t.Errorf("CheckExplainPlan with seq scans allowed: %v", err)
+	}
+
+	plan.TotalCost = 5000
+	if err := CheckExplainPlan(AllowSeqScans(context.Background()), nil, plan, StatementTypeSelect); err == nil {
+		t.Error("CheckExplainPlan with seq scans allowed ignored the cost limit")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Result output formats accepted by the `format` tool argument
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "markdown"
+	FormatTable    = "table"
+)
+
+// NullText is how NULL is rendered by the text based formats
+const NullText = "NULL"
+
+// FormatResult renders rows in the requested format
+func FormatResult(m []map[string]interface{}, columns []Column, format string) (string, error) {
+	headers := ColumnNames(columns)
+	switch format {
+	case "", FormatCSV:
+		return MapToCSV(m, headers)
+	case FormatJSON:
+		return MapToJSON(m, headers)
+	case FormatJSONL:
+		return MapToJSONL(m, headers)
+	case FormatMarkdown:
+		return MapToMarkdown(m, columns), nil
+	case FormatTable:
+		return MapToTable(m, columns), nil
+	}
+	return "", ValidateFormat(format)
+}
+
+// ValidateFormat rejects unknown values of the `format` argument
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatCSV, FormatJSON, FormatJSONL, FormatMarkdown, FormatTable:
+		return nil
+	}
+	return Errorf("err_unsupported_format", "unsupported format %q (use csv, json, jsonl, markdown or table)", format)
+}
+
+// MapToJSON renders rows as a JSON array of objects with columns in result order
+func MapToJSON(m []map[string]interface{}, headers []string) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, item := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSONObject(&buf, item, headers); err != nil {
+			return "", err
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String(), nil
+}
+
+// MapToJSONL renders rows as one JSON object per line
+func MapToJSONL(m []map[string]interface{}, headers []string) (string, error) {
+	var buf bytes.Buffer
+	for _, item := range m {
+		if err := writeJSONObject(&buf, item, headers); err != nil {
+			return "", err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+func writeJSONObject(buf *bytes.Buffer, item map[string]interface{}, headers []string) error {
+	buf.WriteByte('{')
+	for i, header := range headers {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(header)
+		if err != nil {
+			return fmt.Errorf("failed to encode column %s: %v", header, err)
+		}
+		value, err := json.Marshal(jsonValue(item[header]))
+		if err != nil {
+			return fmt.Errorf("failed to encode value of column %s: %v", header, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// MapToMarkdown renders rows as a GitHub flavored markdown table whose header
+// cells carry the column type, e.g. "id (int4)"
+func MapToMarkdown(m []map[string]interface{}, columns []Column) string {
+	var b strings.Builder
+	escape := strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>")
+	headers := ColumnNames(columns)
+
+	b.WriteString("|")
+	for _, col := range columns {
+		b.WriteString(" " + escape.Replace(columnHeader(col)) + " |")
+	}
+	b.WriteString("\n|")
+	for range headers {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+
+	for _, item := range m {
+		b.WriteString("|")
+		for _, header := range headers {
+			b.WriteString(" " + escape.Replace(textValue(item[header])) + " |")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// MapToTable renders rows as a column-aligned plain text table whose header
+// carries the column types
+func MapToTable(m []map[string]interface{}, columns []Column) string {
+	flatten := strings.NewReplacer("\r\n", "\\n", "\n", "\\n", "\t", "\\t")
+	headers := ColumnNames(columns)
+	labels := make([]string, len(columns))
+
+	cells := make([][]string, len(m))
+	widths := make([]int, len(headers))
+	for i, col := range columns {
+		labels[i] = columnHeader(col)
+		widths[i] = utf8.RuneCountInString(labels[i])
+	}
+	for r, item := range m {
+		cells[r] = make([]string, len(headers))
+		for i, header := range headers {
+			cells[r][i] = flatten.Replace(textValue(item[header]))
+			if w := utf8.RuneCountInString(cells[r][i]); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	var b strings.Builder
+	writeLine := func(values []string) {
+		for i, value := range values {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(value)
+			if i < len(values)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value)))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	writeLine(labels)
+	for i := range headers {
+		if i > 0 {
+			b.WriteString("-+-")
+		}
+		b.WriteString(strings.Repeat("-", widths[i]))
+	}
+	b.WriteString("\n")
+	for _, row := range cells {
+		writeLine(row)
+	}
+	b.WriteString(Tf("table_rows", "(%d rows)", len(m)) + "\n")
+	return b.String()
+}
+
+func columnHeader(col Column) string {
+	if col.Type == "" {
+		return col.Name
+	}
+	return fmt.Sprintf("%s (%s)", col.Name, col.Type)
+}
+
+// textValue renders a single value for the text based formats
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return NullText
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	case json.RawMessage:
+		return string(v)
+	case []interface{}:
+		// Arrays read as JSON arrays rather than Go's [a b] syntax
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// jsonValue converts a driver value into something encoding/json renders faithfully
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatResult(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": int64(1), "note": "a|b"},
+		{"id": int64(2), "note": "line1\nline2"},
+		{"id": int64(10), "note": nil},
+	}
+	columns := []Column{{Name: "id"}, {Name: "note"}}
+	typed := []Column{{Name: "id", Type: "int4"}, {Name: "note", Type: "text"}}
+
+	tests := []struct {
+		name    string
+		rows    []map[string]interface{}
+		columns []Column
+		format  string
+		want    string
+	}{
+		{"json", rows, columns, FormatJSON,
+			`[{"id":1,"note":"a|b"},{"id":2,"note":"line1\nline2"},{"id":10,"note":null}]`},
+		{"json without rows", nil, columns, FormatJSON, `[]`},
+		{"jsonl", rows, columns, FormatJSONL,
+			`{"id":1,"note":"a|b"}` + "\n" +
+				`{"id":2,"note":"line1\nline2"}` + "\n" +
+				`{"id":10,"note":null}` + "\n"},
+		{"jsonl keeps column order", []map[string]interface{}{{"b": 1, "a": 2}}, []Column{{Name: "b"}, {Name: "a"}}, FormatJSONL,
+			`{"b":1,"a":2}` + "\n"},
+		{"markdown escapes pipes and newlines", rows, columns, FormatMarkdown,
+			"| id | note |\n" +
+				"| --- | --- |\n" +
+				"| 1 | a\\|b |\n" +
+				"| 2 | line1<br>line2 |\n" +
+				"| 10 | NULL |\n"},
+		{"markdown with types", rows[:1], typed, FormatMarkdown,
+			"| id (int4) | note (text) |\n" +
+				"| --- | --- |\n" +
+				"| 1 | a\\|b |\n"},
+		{"table aligns columns", rows, columns, FormatTable,
+			"id | note\n" +
+				"---+-------------\n" +
+				"1  | a|b\n" +
+				"2  | line1\\nline2\n" +
+				"10 | NULL\n" +
+				"(3 rows)\n"},
+		{"table aligns by characters", []map[string]interface{}{{"id": "äöü", "note": "x"}, {"id": "abcd", "note": "y"}}, columns, FormatTable,
+			"id   | note\n" +
+				"-----+-----\n" +
+				"äöü  | x\n" +
+				"abcd | y\n" +
+				"(2 rows)\n"},
+		{"table with types", rows[2:], typed, FormatTable,
+			"id (int4) | note (text)\n" +
+				"----------+------------\n" +
+				"10        | NULL\n" +
+				"(1 rows)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FormatResult(tt.rows, tt.columns, tt.format)
+			if err != nil {
+				t.Fatalf("FormatResult: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FormatResult =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatResultUnsupported(t *testing.T) {
+	if _, err := FormatResult(nil, nil, "xml"); err == nil || !strings.Contains(err.Error(), `"xml"`) {
+		t.Errorf("FormatResult error = %v, want unsupported format", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*
+var localeFS embed.FS
+
+// localizer translates messages to --lang. Until InitLocalization runs,
+// messages keep their English defaults.
+var localizer *i18n.Localizer
+
+// InitLocalization loads the English messages and those of lang. Messages
+// missing from lang fall back to English one by one.
+func InitLocalization(lang string) {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		log.Printf("Warning: unknown language %q, using English", lang)
+		tag = language.English
+	}
+
+	bundle := i18n.NewBundle(language.English)
+	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	for _, name := range []string{language.English.String(), tag.String()} {
+		file := fmt.Sprintf("locales/%s/active.%s.toml", name, name)
+		data, err := localeFS.ReadFile(file)
+		if err != nil {
+			if name != language.English.String() {
+				log.Printf("Warning: no translations for %s, using English", name)
+			}
+			continue
+		}
+		if _, err := bundle.ParseMessageFileBytes(data, file); err != nil {
+			log.Printf("Warning: failed to load %s: %v", file, err)
+		}
+	}
+
+	localizer = i18n.NewLocalizer(bundle, tag.String(), language.English.String())
+}
+
+// T returns the message [gomcp] id in the selected language, or message,
+// its English default, when no locale file has it
+func T(id, message string) string {
+	if localizer == nil {
+		return message
+	}
+	text, err := localizer.Localize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{ID: "gomcp." + id, Other: message},
+	})
+	if text == "" || (err != nil && !errors.As(err, new(*i18n.MessageNotFoundErr))) {
+		return message
+	}
+	return text
+}
+
+// Tf formats the localized message id like fmt.Sprintf
+func Tf(id, format string, args ...interface{}) string {
+	return fmt.Sprintf(T(id, format), args...)
+}
+
+// Errorf formats the localized message id like fmt.Errorf, so %w still wraps
+func Errorf(id, format string, args ...interface{}) error {
+	return fmt.Errorf(T(id, format), args...)
+}
@@ -2,75 +2,195 @@ package main
 
 import (
 	"context"
-	"embed"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
-	"github.com/nicksnyder/go-i18n/v2/i18n"
-	"github.com/pelletier/go-toml/v2"
 	"golang.org/x/text/language"
 )
 
-//go:embed locales/*
-var localeFS embed.FS
-
 const (
 	StatementTypeNoExplainCheck = ""
 	StatementTypeSelect         = "SELECT"
 	StatementTypeInsert         = "INSERT"
 	StatementTypeUpdate         = "UPDATE"
 	StatementTypeDelete         = "DELETE"
+	StatementTypeCreateTable    = "CREATE TABLE"
+	StatementTypeAlterTable     = "ALTER TABLE"
+	StatementTypeCreateIndex    = "CREATE INDEX"
+	StatementTypeExplain        = "EXPLAIN"
+	StatementTypeComment        = "COMMENT"
+	StatementTypeOther          = "OTHER"
 )
 
 var (
 	DSN              string
 	ReadOnly         bool
 	WithExplainCheck bool
-	DB               *sqlx.DB
-	Transport        string
-	IPaddress        string
-	Port             int
-	Lang             string
+	// Explain check policies, zero disables a rule
+	ExplainMaxSeqScanRows float64
+	ExplainMaxCost        float64
+	ExplainMaxRows        float64
+	QueryTimeout          time.Duration
+	MaxRows               int
+	CursorIdleTimeout     time.Duration
+	Transport             string
+	IPaddress             string
+	Port                  int
+	Lang                  string
 )
 
 func main() {
-	// Initialize i18n
-	bundle := i18n.NewBundle(language.English)
-	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-
-	flag.StringVar(&DSN, "dsn", "", "PostgreSQL DSN")
+	var connectionFlags ConnectionFlag
+	configFile := flag.String("config", os.Getenv(EnvPrefix+"CONFIG"), "Path to a TOML config file")
+	flag.StringVar(&DSN, "dsn", "", "PostgreSQL DSN (defaults to the PG* environment variables)")
+	dsnFile := flag.String("dsn-file", "", "Read the DSN from this file")
+	passwordFile := flag.String("password-file", "", "Read the password of the --dsn connection from this file")
+	flag.Var(&connectionFlags, "connection", "Additional named connection as name=dsn (repeatable)")
+	defaultConnection := flag.String("default-connection", "", "Connection used by tool calls without a connection argument")
+	allowDatabases := flag.String("allow-databases", "", "Comma separated glob patterns of databases the database argument may name (default: all)")
+	denyDatabases := flag.String("deny-databases", "", "Comma separated glob patterns of databases the database argument may not name")
 	flag.BoolVar(&ReadOnly, "read-only", false, "Enable read-only mode")
 	flag.BoolVar(&WithExplainCheck, "with-explain-check", false, "Check query plan with EXPLAIN before executing")
-	flag.StringVar(&Transport, "t", "stdio", "Transport type (stdio or sse)")
-	flag.IntVar(&Port, "port", 8080, "SSE server port")
+	flag.Float64Var(&ExplainMaxSeqScanRows, "explain-max-seq-scan-rows", 100000, "Reject sequential scans over tables with more rows than this (0 disables)")
+	flag.Float64Var(&ExplainMaxCost, "explain-max-cost", 0, "Reject plans with a higher estimated total cost (0 disables)")
+	flag.Float64Var(&ExplainMaxRows, "explain-max-rows", 0, "Reject plans estimating more result rows (0 disables)")
+	flag.DurationVar(&QueryTimeout, "query-timeout", 0, "Maximum execution time per tool call, e.g. 30s (0 disables)")
+	flag.IntVar(&MaxRows, "max-rows", 1000, "Maximum rows returned by a single read_query page")
+	flag.DurationVar(&CursorIdleTimeout, "cursor-idle-timeout", 5*time.Minute, "How long an unread result cursor stays open")
+	flag.IntVar(&MaxOpenConns, "max-open-conns", 10, "Maximum open database connections (0 for unlimited)")
+	flag.IntVar(&MaxIdleConns, "max-idle-conns", 5, "Maximum idle database connections kept in the pool")
+	flag.DurationVar(&ConnMaxLifetime, "conn-max-lifetime", 30*time.Minute, "Maximum lifetime of a database connection (0 for unlimited)")
+	flag.DurationVar(&ConnMaxIdleTime, "conn-max-idle-time", 5*time.Minute, "Close connections idle for longer than this (0 for unlimited)")
+	flag.DurationVar(&HealthCheckInterval, "health-check-interval", 30*time.Second, "Interval between database health pings (0 disables)")
+	flag.DurationVar(&ResourceRefreshInterval, "resource-refresh-interval", 30*time.Second, "How often schema resources are checked for catalog changes (0 disables)")
+	flag.StringVar(&PromptsDir, "prompts-dir", "", "Directory of prompt definition files (*.toml)")
+	flag.StringVar(&Transport, "t", TransportStdio, "Transport type (stdio, sse or http)")
+	flag.IntVar(&Port, "port", 8080, "SSE/HTTP server port")
 	flag.StringVar(&IPaddress, "ip", "localhost", "Server IP address")
+	flag.StringVar(&HTTPPath, "http-path", "/mcp", "Endpoint of the Streamable HTTP transport")
+	flag.BoolVar(&HTTPStateful, "http-stateful", false, "Track HTTP sessions in memory and reject unknown session IDs (needs sticky sessions)")
+	flag.DurationVar(&HTTPHeartbeatInterval, "http-heartbeat-interval", 30*time.Second, "Interval between pings on idle HTTP streams (0 disables)")
+	flag.DurationVar(&HTTPReplayWindow, "http-replay-window", 5*time.Minute, "How long events of dropped HTTP streams can be replayed with Last-Event-ID (0 disables)")
 	flag.StringVar(&Lang, "lang", language.English.String(), "Language code (en/zh-CN/...)")
+	aclReadAllow := flag.String("acl-read-allow", "", "Comma separated schema or schema.table patterns that may be read (default: all)")
+	aclReadDeny := flag.String("acl-read-deny", "", "Comma separated schema, schema.table or schema.table.column patterns that may not be read")
+	aclWriteAllow := flag.String("acl-write-allow", "", "Comma separated schema or schema.table patterns that may be written (default: all)")
+	aclWriteDeny := flag.String("acl-write-deny", "", "Comma separated schema, schema.table or schema.table.column patterns that may not be written")
+	aclAllowFunctions := flag.String("acl-allow-functions", "", "Comma separated patterns of table or file reading functions that may be called while read rules are set")
+	var maskFlags MaskFlag
+	flag.Var(&maskFlags, "mask", "Mask result columns as pattern=strategy, e.g. *email*=partial (repeatable)")
+	flag.StringVar(&MaskHashKey, "mask-hash-key", "", "Key for the hash masking strategy (default: random per start)")
+	flag.StringVar(&AuditFile, "audit-log", "", "Append a JSON line for every tool call to this file")
+	flag.StringVar(&AuditTable, "audit-table", "", "Insert a row for every tool call into this table, created if missing")
+	flag.StringVar(&AuditConnection, "audit-connection", "", "Connection holding --audit-table (default: the default connection)")
+	flag.BoolVar(&AuditRedactLiterals, "audit-redact-literals", true, "Replace literal values in audited SQL with $n placeholders")
+	flag.StringVar(&TLSCertFile, "tls-cert", "", "PEM certificate to serve the SSE/HTTP transports over HTTPS")
+	flag.StringVar(&TLSKeyFile, "tls-key", "", "PEM private key of --tls-cert")
+	flag.StringVar(&TLSClientCAFile, "tls-client-ca", "", "PEM CA certificates that must sign client certificates (enables mTLS)")
+	var authTokenFlags AuthTokenFlag
+	flag.Var(&authTokenFlags, "auth-token", "Accept a static bearer token or API key as identity=token on the SSE/HTTP transports (repeatable)")
+	flag.StringVar(&AuthJWKSFile, "auth-jwks", "", "JWKS file whose keys verify JWT access tokens on the SSE/HTTP transports")
+	flag.StringVar(&AuthIssuer, "auth-issuer", "", "Required issuer (iss) of JWT access tokens")
+	flag.StringVar(&AuthAudience, "auth-audience", "", "Required audience (aud) of JWT access tokens, the URI clients use for this server")
+	flag.StringVar(&AuthIdentityClaim, "auth-identity-claim", "sub", "JWT claim naming the caller")
+	authReadWrite := flag.String("auth-read-write", "", "Comma separated identity patterns granted read-write access (default: all read-only)")
+	flag.StringVar(&AuthWriteScope, "auth-write-scope", "", "JWT scope granting read-write access")
+	enableTools := flag.String("enable-tools", "", "Comma separated names of the only tools to offer (default: all)")
+	disableTools := flag.String("disable-tools", "", "Comma separated names of tools not to offer")
+
+	// Nothing the server logs, including flag errors, may reveal a password
+	log.SetOutput(NewRedactingWriter(os.Stderr))
+	flag.CommandLine.SetOutput(NewRedactingWriter(os.Stderr))
+	UseSystemServiceFile()
 
 	flag.Parse()
 
-	langTag, err := language.Parse(Lang)
-	if err != nil {
-		langTag = language.English
+	if err := LoadConfig(*configFile); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if err := ValidateSettings(); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if err := InitMasking(); err != nil {
+		log.Fatalf("Failed to initialize masking: %v", err)
+	}
+	if err := InitAuth(); err != nil {
+		log.Fatalf("Failed to initialize authentication: %v", err)
+	}
+
+	var err error
+	if AllowedDatabases, err = ParsePatternList(*allowDatabases); err != nil {
+		log.Fatalf("--allow-databases: %v", err)
+	}
+	if DeniedDatabases, err = ParsePatternList(*denyDatabases); err != nil {
+		log.Fatalf("--deny-databases: %v", err)
+	}
+	if AuthReadWrite, err = ParsePatternList(*authReadWrite); err != nil {
+		log.Fatalf("--auth-read-write: %v", err)
+	}
+
+	if AllowedFunctions, err = ParsePatternList(*aclAllowFunctions); err != nil {
+		log.Fatalf("--acl-allow-functions: %v", err)
+	}
+	for _, acl := range []struct {
+		name   string
+		value  string
+		allow  bool
+		target *[]string
+	}{
+		{"--acl-read-allow", *aclReadAllow, true, &ReadACL.Allow},
+		{"--acl-read-deny", *aclReadDeny, false, &ReadACL.Deny},
+		{"--acl-write-allow", *aclWriteAllow, true, &WriteACL.Allow},
+		{"--acl-write-deny", *aclWriteDeny, false, &WriteACL.Deny},
+	} {
+		if *acl.target, err = ParseACLPatterns(acl.value, acl.allow); err != nil {
+			log.Fatalf("%s: %v", acl.name, err)
+		}
+	}
+	if EnabledTools, err = ParsePatternList(*enableTools); err != nil {
+		log.Fatalf("--enable-tools: %v", err)
+	}
+	if DisabledTools, err = ParsePatternList(*disableTools); err != nil {
+		log.Fatalf("--disable-tools: %v", err)
+	}
+
+	if DSN, err = resolveDSN(DSN, *dsnFile); err != nil {
+		log.Fatalf("Configuration error: %v", err)
 	}
 
-	langFile := fmt.Sprintf("locales/%s/active.%s.toml", langTag.String(), langTag.String())
-	if data, err := localeFS.ReadFile(langFile); err == nil {
-		bundle.ParseMessageFileBytes(data, langFile)
-	} else {
-		if enData, err := localeFS.ReadFile("locales/en/active.en.toml"); err == nil {
-			bundle.ParseMessageFileBytes(enData, "locales/en/active.en.toml")
+	// --dsn is the default connection; without any other connection it may
+	// also be empty and rely on the PG* environment variables
+	if DSN != "" || *passwordFile != "" || len(connections) == 0 {
+		if err := RegisterConnection(DefaultConnectionName, DSN, *passwordFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+		DefaultConnection = DefaultConnectionName
+	}
+	if *defaultConnection != "" {
+		if _, ok := connections[*defaultConnection]; !ok {
+			log.Fatalf("Configuration error: default connection %q is not defined", *defaultConnection)
 		}
+		DefaultConnection = *defaultConnection
+	}
+
+	if err := InitAudit(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	localizer := i18n.NewLocalizer(bundle, langTag.String())
-	_ = localizer // Reserved for future localization
+	InitLocalization(Lang)
+
+	hooks := &server.Hooks{}
+	hooks.AddBeforeCallTool(RememberRequestID)
+	hooks.AddOnUnregisterSession(ForgetSubscriptions)
 
 	// Create MCP server
 	s := server.NewMCPServer(
@@ -79,68 +199,128 @@ func main() {
 		server.WithResourceCapabilities(true, true),
 		server.WithPromptCapabilities(true),
 		server.WithLogging(),
+		server.WithHooks(hooks),
+		server.WithToolHandlerMiddleware(AuditMiddleware),
+		server.WithToolHandlerMiddleware(QueryContextMiddleware),
+		server.WithToolHandlerMiddleware(ConnectionMiddleware),
+		server.WithToolFilter(FilterWriteTools),
+	)
+	s.AddNotificationHandler("notifications/cancelled", HandleCancelledNotification)
+
+	// Shared by every tool: per-call override of --query-timeout
+	timeoutParam := mcp.WithNumber("timeout_ms", mcp.Description(T("param_timeout_ms", "Maximum execution time in milliseconds (optional, defaults to --query-timeout)")))
+	// Shared by every database tool: which configured connection to use
+	connectionParam := mcp.WithString("connection", mcp.Description(T("param_connection", "Name of the connection to use (optional, see list_connections)")))
+	// Shared by every database tool: sibling database on the same server
+	databaseParam := mcp.WithString("database", mcp.Description(T("param_database", "Database on the connection's server to use (optional, defaults to the one in its DSN)")))
+	// Shared by read_query and the schema tools
+	formatParam := mcp.WithString("format",
+		mcp.Description(T("param_format", "Output format (optional, defaults to csv)")),
+		mcp.Enum(FormatCSV, FormatJSON, FormatJSONL, FormatMarkdown, FormatTable),
 	)
 
 	// Schema Tools
+	listConnectionsTool := mcp.NewTool(
+		"list_connections",
+		mcp.WithDescription(T("list_connections", "List the database connections this server can use")),
+		formatParam,
+	)
+
 	listDatabaseTool := mcp.NewTool(
 		"list_databases",
-		mcp.WithDescription("List all databases in the PostgreSQL server"),
+		mcp.WithDescription(T("list_database", "List all databases in the PostgreSQL server")),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	listTableTool := mcp.NewTool(
 		"list_tables",
-		mcp.WithDescription("List all tables in the current database"),
-		mcp.WithString("schema", mcp.Description("Schema name (optional, defaults to all schemas)")),
+		mcp.WithDescription(T("list_table", "List all tables in the current database")),
+		mcp.WithString("schema", mcp.Description(T("param_schema_all", "Schema name (optional, defaults to all schemas)"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	listColumnsTool := mcp.NewTool(
 		"list_columns",
-		mcp.WithDescription("List all columns for a specific table"),
-		mcp.WithString("table_name", mcp.Required(), mcp.Description("Name of the table")),
-		mcp.WithString("schema", mcp.Description("Schema name (optional, defaults to 'public')")),
+		mcp.WithDescription(T("list_columns", "List all columns for a specific table")),
+		mcp.WithString("table_name", mcp.Required(), mcp.Description(T("param_table_name", "Name of the table"))),
+		mcp.WithString("schema", mcp.Description(T("param_schema_public", "Schema name (optional, defaults to 'public')"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	descTableTool := mcp.NewTool(
 		"describe_table",
-		mcp.WithDescription("Get detailed table structure with constraints and indexes"),
-		mcp.WithString("name", mcp.Required(), mcp.Description("Name of the table to describe")),
-		mcp.WithString("schema", mcp.Description("Schema name (optional, defaults to 'public')")),
+		mcp.WithDescription(T("desc_table", "Get detailed table structure with constraints and indexes")),
+		mcp.WithString("name", mcp.Required(), mcp.Description(T("desc_table_name", "Name of the table to describe"))),
+		mcp.WithString("schema", mcp.Description(T("param_schema_public", "Schema name (optional, defaults to 'public')"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	getTableSizeTool := mcp.NewTool(
 		"get_table_size",
-		mcp.WithDescription("Get table size and row count information"),
-		mcp.WithString("table_name", mcp.Required(), mcp.Description("Name of the table")),
-		mcp.WithString("schema", mcp.Description("Schema name (optional, defaults to 'public')")),
+		mcp.WithDescription(T("get_table_size", "Get table size and row count information")),
+		mcp.WithString("table_name", mcp.Required(), mcp.Description(T("param_table_name", "Name of the table"))),
+		mcp.WithString("schema", mcp.Description(T("param_schema_public", "Schema name (optional, defaults to 'public')"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	listIndexesTool := mcp.NewTool(
 		"list_indexes",
-		mcp.WithDescription("List all indexes for a table or database"),
-		mcp.WithString("table_name", mcp.Description("Name of the table (optional, lists all if empty)")),
-		mcp.WithString("schema", mcp.Description("Schema name (optional)")),
+		mcp.WithDescription(T("list_indexes", "List all indexes for a table or database")),
+		mcp.WithString("table_name", mcp.Description(T("list_indexes_table_name", "Name of the table (optional, lists all if empty)"))),
+		mcp.WithString("schema", mcp.Description(T("param_schema", "Schema name (optional)"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	// Query Tools
 	readQueryTool := mcp.NewTool(
 		"read_query",
-		mcp.WithDescription("Execute a read-only SQL query with safety checks"),
-		mcp.WithString("query", mcp.Required(), mcp.Description("SQL SELECT query to execute")),
+		mcp.WithDescription(T("read_query", "Execute a read-only SQL query with safety checks. Make sure you have knowledge of the table structure before writing WHERE conditions. Call `describe_table` first if necessary")),
+		mcp.WithString("query", mcp.Description(T("read_query_query", "SQL SELECT query to execute (required unless cursor is given)"))),
+		mcp.WithNumber("limit", mcp.Description(T("read_query_limit", "Maximum rows to return (optional, defaults to and capped by --max-rows)"))),
+		mcp.WithString("cursor", mcp.Description(T("read_query_cursor", "Continuation token from a truncated result, fetches the next page"))),
+		formatParam,
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	explainQueryTool := mcp.NewTool(
 		"explain_query",
-		mcp.WithDescription("Analyze query execution plan"),
-		mcp.WithString("query", mcp.Required(), mcp.Description("SQL query to analyze")),
-		mcp.WithBoolean("analyze", mcp.Description("Run EXPLAIN ANALYZE (default: false)")),
+		mcp.WithDescription(T("explain_query", "Analyze query execution plan")),
+		mcp.WithString("query", mcp.Required(), mcp.Description(T("explain_query_query", "SQL query to analyze"))),
+		mcp.WithBoolean("analyze", mcp.Description(T("explain_query_analyze", "Run EXPLAIN ANALYZE (default: false)"))),
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	countQueryTool := mcp.NewTool(
 		"count_query",
-		mcp.WithDescription("Count rows in a table with optional conditions"),
-		mcp.WithString("table_name", mcp.Required(), mcp.Description("Name of the table")),
-		mcp.WithString("where_clause", mcp.Description("Optional WHERE conditions")),
-		mcp.WithString("schema", mcp.Description("Schema name (optional, defaults to 'public')")),
+		mcp.WithDescription(T("count_query", "Count rows in a table with optional conditions")),
+		mcp.WithString("table_name", mcp.Required(), mcp.Description(T("count_query_name", "Name of the table to count"))),
+		mcp.WithString("where_clause", mcp.Description(T("count_query_where", "Optional WHERE conditions"))),
+		mcp.WithString("schema", mcp.Description(T("param_schema_public", "Schema name (optional, defaults to 'public')"))),
+		connectionParam,
+		databaseParam,
+		timeoutParam,
 	)
 
 	// Write Tools (only if not read-only)
@@ -149,70 +329,119 @@ func main() {
 	if !ReadOnly {
 		writeQueryTool = mcp.NewTool(
 			"write_query",
-			mcp.WithDescription("Execute an INSERT query"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("SQL INSERT query to execute")),
+			mcp.WithDescription(T("write_query", "Execute an INSERT query. Make sure you have knowledge of the table structure before executing the query. Make sure the data types match the columns' definitions")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("write_query_query", "SQL INSERT query to execute"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 
 		updateQueryTool = mcp.NewTool(
 			"update_query",
-			mcp.WithDescription("Execute an UPDATE query with WHERE clause validation"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("SQL UPDATE query to execute")),
+			mcp.WithDescription(T("update_query", "Execute an UPDATE query with WHERE clause validation. Make sure you have knowledge of the table structure before executing the query. Call `describe_table` first if necessary")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("update_query_query", "SQL UPDATE query to execute"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 
 		deleteQueryTool = mcp.NewTool(
 			"delete_query",
-			mcp.WithDescription("Execute a DELETE query with WHERE clause validation"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("SQL DELETE query to execute")),
+			mcp.WithDescription(T("delete_query", "Execute a DELETE query with WHERE clause validation. Make sure you have knowledge of the table structure before executing the query. Call `describe_table` first if necessary")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("delete_query_query", "SQL DELETE query to execute"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 
 		createTableTool = mcp.NewTool(
 			"create_table",
-			mcp.WithDescription("Create a new table"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("CREATE TABLE SQL statement")),
+			mcp.WithDescription(T("create_table", "Create a new table. Add COMMENT ON statements for the table and each column after the CREATE TABLE statement")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("create_table_query_description", "The SQL query to create the table"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 
 		alterTableTool = mcp.NewTool(
 			"alter_table",
-			mcp.WithDescription("Alter an existing table structure"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("ALTER TABLE SQL statement")),
+			mcp.WithDescription(T("alter_table", "Alter an existing table structure. Update the comments of each modified column with COMMENT ON statements after the ALTER TABLE statement. DO NOT drop table or existing columns!")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("alter_table_query", "The SQL query to alter the table"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 
 		createIndexTool = mcp.NewTool(
 			"create_index",
-			mcp.WithDescription("Create an index on a table"),
-			mcp.WithString("query", mcp.Required(), mcp.Description("CREATE INDEX SQL statement")),
+			mcp.WithDescription(T("create_index", "Create an index on a table")),
+			mcp.WithString("query", mcp.Required(), mcp.Description(T("create_index_query", "CREATE INDEX SQL statement"))),
+			connectionParam,
+			databaseParam,
+			timeoutParam,
 		)
 	}
 
 	// Add tool handlers
-	s.AddTool(listDatabaseTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		result, err := HandleQuery("SELECT datname, pg_database_size(datname) as size_bytes, pg_size_pretty(pg_database_size(datname)) as size FROM pg_database WHERE datistemplate = false ORDER BY datname", StatementTypeNoExplainCheck)
+	offered := map[string]bool{}
+	addTool := func(tool mcp.Tool, handler server.ToolHandlerFunc) {
+		offered[tool.Name] = true
+		if !ToolEnabled(tool.Name) {
+			return
+		}
+		tool, err := CustomizeTool(tool)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			log.Fatalf("Configuration error: %v", err)
 		}
-		return mcp.NewToolResultText(result), nil
+		s.AddTool(tool, HideArguments(tool.Name, handler))
+	}
+
+	addTool(listConnectionsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		rows, columns := ListConnections()
+		result, err := QueryToolResult(rows, columns, getStringParam(request, "format", FormatCSV), 0, "")
+		if err != nil {
+			return ErrorResult(err), nil
+		}
+		return result, nil
+	})
+
+	addTool(listDatabaseTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		format := getStringParam(request, "format", FormatCSV)
+		result, err := HandleQuery(ctx, "SELECT datname, pg_database_size(datname) as size_bytes, pg_size_pretty(pg_database_size(datname)) as size FROM pg_database WHERE datistemplate = false ORDER BY datname", StatementTypeNoExplainCheck, format)
+		if err != nil {
+			return ErrorResult(err), nil
+		}
+		return result, nil
 	})
 
-	s.AddTool(listTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(listTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		schema := getStringParam(request, "schema", "")
+		format := getStringParam(request, "format", FormatCSV)
 		query := "SELECT table_schema, table_name, table_type FROM information_schema.tables"
+		var args []interface{}
 		if schema != "" {
-			query += fmt.Sprintf(" WHERE table_schema = '%s'", schema)
+			query += " WHERE table_schema = $1"
+			args = append(args, schema)
 		}
 		query += " ORDER BY table_schema, table_name"
 
-		result, err := HandleQuery(query, StatementTypeNoExplainCheck)
+		result, err := HandleTableListQuery(ctx, query, format, "table_schema", "table_name", args...)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(listColumnsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(listColumnsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		tableName := getStringParam(request, "table_name", "")
 		schema := getStringParam(request, "schema", "public")
+		format := getStringParam(request, "format", FormatCSV)
 
-		query := fmt.Sprintf(`
+		if err := CheckTableAccess(AccessRead, schema, tableName); err != nil {
+			return ErrorResult(err), nil
+		}
+
+		query := `
 			SELECT
 				column_name,
 				data_type,
@@ -223,35 +452,43 @@ func main() {
 				column_default,
 				ordinal_position
 			FROM information_schema.columns
-			WHERE table_name = '%s' AND table_schema = '%s'
-			ORDER BY ordinal_position`, tableName, schema)
+			WHERE table_name = $1 AND table_schema = $2
+			ORDER BY ordinal_position`
 
-		result, err := HandleQuery(query, StatementTypeNoExplainCheck)
+		result, err := HandleQuery(ctx, query, StatementTypeNoExplainCheck, format, tableName, schema)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(descTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(descTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		tableName := getStringParam(request, "name", "")
 		schema := getStringParam(request, "schema", "public")
+		format := getStringParam(request, "format", FormatCSV)
+
+		if err := ValidateFormat(format); err != nil {
+			return ErrorResult(err), nil
+		}
+		if err := CheckTableAccess(AccessRead, schema, tableName); err != nil {
+			return ErrorResult(err), nil
+		}
 
 		// Get comprehensive table description
 		queries := []string{
 			// Columns
-			fmt.Sprintf(`
+			`
 				SELECT
 					'COLUMN' as type,
 					column_name as name,
 					data_type || COALESCE('(' || character_maximum_length::text || ')', '') as details,
 					CASE WHEN is_nullable = 'NO' THEN 'NOT NULL' ELSE 'NULLABLE' END as constraint_info
 				FROM information_schema.columns
-				WHERE table_name = '%s' AND table_schema = '%s'
-				ORDER BY ordinal_position`, tableName, schema),
+				WHERE table_name = $1 AND table_schema = $2
+				ORDER BY ordinal_position`,
 
 			// Constraints
-			fmt.Sprintf(`
+			`
 				SELECT
 					'CONSTRAINT' as type,
 					tc.constraint_name as name,
@@ -260,66 +497,85 @@ func main() {
 				FROM information_schema.table_constraints tc
 				LEFT JOIN information_schema.key_column_usage kcu
 					ON tc.constraint_name = kcu.constraint_name
-				WHERE tc.table_name = '%s' AND tc.table_schema = '%s'
-				GROUP BY tc.constraint_name, tc.constraint_type`, tableName, schema),
+				WHERE tc.table_name = $1 AND tc.table_schema = $2
+				GROUP BY tc.constraint_name, tc.constraint_type`,
 
 			// Indexes
-			fmt.Sprintf(`
+			`
 				SELECT
 					'INDEX' as type,
 					indexname as name,
 					'' as details,
 					indexdef as constraint_info
 				FROM pg_indexes
-				WHERE tablename = '%s' AND schemaname = '%s'`, tableName, schema),
+				WHERE tablename = $1 AND schemaname = $2`,
 		}
 
-		var allResults []string
+		// All three queries share their columns, so they merge into one result
+		start := time.Now()
+		var allRows []map[string]interface{}
+		var columns []Column
 		for _, query := range queries {
-			result, err := HandleQuery(query, StatementTypeNoExplainCheck)
-			if err == nil && result != "" {
-				allResults = append(allResults, result)
+			rows, cols, err := DoQuery(ctx, query, StatementTypeNoExplainCheck, tableName, schema)
+			if err != nil {
+				return ErrorResult(err), nil
 			}
+			allRows = append(allRows, rows...)
+			columns = cols
 		}
 
-		if len(allResults) == 0 {
-			return mcp.NewToolResultText("Table not found or no information available"), nil
+		if len(allRows) == 0 {
+			return mcp.NewToolResultError(T("err_table_not_found", "Table not found or no information available")), nil
 		}
 
-		return mcp.NewToolResultText(strings.Join(allResults, "\n\n")), nil
+		result, err := QueryToolResult(allRows, columns, format, time.Since(start), "")
+		if err != nil {
+			return ErrorResult(err), nil
+		}
+		return result, nil
 	})
 
-	s.AddTool(getTableSizeTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(getTableSizeTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		tableName := getStringParam(request, "table_name", "")
 		schema := getStringParam(request, "schema", "public")
+		format := getStringParam(request, "format", FormatCSV)
 
+		if err := CheckTableAccess(AccessRead, schema, tableName); err != nil {
+			return ErrorResult(err), nil
+		}
+
+		relation := QuoteIdentifier(schema, tableName)
 		query := fmt.Sprintf(`
 			SELECT
-				'%s.%s' as table_name,
-				pg_size_pretty(pg_total_relation_size('%s.%s')) as total_size,
-				pg_size_pretty(pg_relation_size('%s.%s')) as table_size,
-				(SELECT COUNT(*) FROM %s.%s) as estimated_rows
-		`, schema, tableName, schema, tableName, schema, tableName, schema, tableName)
+				$1::text as table_name,
+				pg_size_pretty(pg_total_relation_size($1::regclass)) as total_size,
+				pg_size_pretty(pg_relation_size($1::regclass)) as table_size,
+				(SELECT COUNT(*) FROM %s) as estimated_rows
+		`, relation)
 
-		result, err := HandleQuery(query, StatementTypeNoExplainCheck)
+		result, err := HandleQuery(ctx, query, StatementTypeNoExplainCheck, format, relation)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(listIndexesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(listIndexesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		tableName := getStringParam(request, "table_name", "")
 		schema := getStringParam(request, "schema", "")
+		format := getStringParam(request, "format", FormatCSV)
 
 		query := "SELECT schemaname, tablename, indexname, indexdef FROM pg_indexes"
 		var conditions []string
+		var args []interface{}
 
 		if tableName != "" {
-			conditions = append(conditions, fmt.Sprintf("tablename = '%s'", tableName))
+			args = append(args, tableName)
+			conditions = append(conditions, fmt.Sprintf("tablename = $%d", len(args)))
 		}
 		if schema != "" {
-			conditions = append(conditions, fmt.Sprintf("schemaname = '%s'", schema))
+			args = append(args, schema)
+			conditions = append(conditions, fmt.Sprintf("schemaname = $%d", len(args)))
 		}
 
 		if len(conditions) > 0 {
@@ -327,271 +583,340 @@ func main() {
 		}
 		query += " ORDER BY schemaname, tablename, indexname"
 
-		result, err := HandleQuery(query, StatementTypeNoExplainCheck)
+		result, err := HandleTableListQuery(ctx, query, format, "schemaname", "tablename", args...)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(readQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(readQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		query := getStringParam(request, "query", "")
-
-		// Safety check - only allow SELECT queries
-		upperQuery := strings.ToUpper(strings.TrimSpace(query))
-		if !strings.HasPrefix(upperQuery, "SELECT") && !strings.HasPrefix(upperQuery, "WITH") {
-			return mcp.NewToolResultText("Error: Only SELECT queries are allowed"), nil
+		cursor := getStringParam(request, "cursor", "")
+		format := getStringParam(request, "format", FormatCSV)
+		limit := int(getNumberParam(request, "limit", float64(MaxRows)))
+		if limit <= 0 || limit > MaxRows {
+			limit = MaxRows
+		}
+		// Reject a bad format before a page is read, which would consume it
+		if err := ValidateFormat(format); err != nil {
+			return ErrorResult(err), nil
+		}
+
+		start := time.Now()
+		var page *Page
+		var err error
+		switch {
+		case cursor != "":
+			page, err = FetchCursor(ctx, cursor, limit)
+		case query != "":
+			page, err = ReadPage(ctx, query, limit)
+		default:
+			return mcp.NewToolResultError(T("err_query_or_cursor", "either query or cursor is required")), nil
+		}
+		if err != nil {
+			return ErrorResult(err), nil
 		}
 
-		result, err := HandleQuery(query, StatementTypeSelect)
+		columns := MaskResult(page.Query, page.Rows, page.Columns)
+		result, err := QueryToolResult(page.Rows, columns, format, time.Since(start), page.Cursor)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(explainQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(explainQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		query := getStringParam(request, "query", "")
 		analyze := getBoolParam(request, "analyze", false)
 
+		stmt, err := ClassifyStatement(query)
+		if err != nil {
+			return ErrorResult(err), nil
+		}
+		if !isExplainable(stmt.Type) {
+			return mcp.NewToolResultError(Tf("err_not_explainable", "%s statements cannot be explained", stmt.Type)), nil
+		}
+		if err := CheckAccess(ctx, query); err != nil {
+			return ErrorResult(err), nil
+		}
+
+		// EXPLAIN ANALYZE executes the statement, so only side-effect free SELECTs qualify
+		if analyze && (stmt.Type != StatementTypeSelect || stmt.ModifiesData) {
+			return mcp.NewToolResultError(T("err_explain_analyze", "EXPLAIN ANALYZE is only allowed for SELECT statements that do not modify data")), nil
+		}
+
 		explainQuery := "EXPLAIN"
 		if analyze {
 			explainQuery += " ANALYZE"
 		}
 		explainQuery += " " + query
 
-		result, err := HandleQuery(explainQuery, StatementTypeNoExplainCheck)
+		// EXPLAIN ANALYZE runs the statement; a READ ONLY transaction stops
+		// volatile functions from writing
+		result, err := HandleQuery(ReadOnlyContext(ctx), explainQuery, StatementTypeNoExplainCheck, FormatCSV)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
-	s.AddTool(countQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	addTool(countQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		tableName := getStringParam(request, "table_name", "")
 		whereClause := getStringParam(request, "where_clause", "")
 		schema := getStringParam(request, "schema", "public")
 
-		query := fmt.Sprintf("SELECT COUNT(*) as count FROM %s.%s", schema, tableName)
+		query := fmt.Sprintf("SELECT COUNT(*) as count FROM %s", QuoteIdentifier(schema, tableName))
 		if whereClause != "" {
 			query += " WHERE " + whereClause
 		}
 
-		result, err := HandleQuery(query, StatementTypeNoExplainCheck)
+		// Counting reads the whole table by design, so only the cost and row
+		// limits of the explain check apply
+		result, err := HandleQuery(AllowSeqScans(ctx), query, StatementTypeSelect, FormatCSV)
 		if err != nil {
-			return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+			return ErrorResult(err), nil
 		}
-		return mcp.NewToolResultText(result), nil
+		return result, nil
 	})
 
 	// Add write tools if not read-only
 	if !ReadOnly && writeQueryTool.Name != "" {
-		s.AddTool(writeQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		addTool(writeQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			query := getStringParam(request, "query", "")
-			result, err := HandleExec(query, StatementTypeInsert)
+			result, err := HandleExec(ctx, query, StatementTypeInsert)
 			if err != nil {
-				return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+				return ErrorResult(err), nil
 			}
-			return mcp.NewToolResultText(result), nil
+			return result, nil
 		})
 
 		if updateQueryTool.Name != "" {
-			s.AddTool(updateQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			addTool(updateQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				query := getStringParam(request, "query", "")
-
-				// Safety check - require WHERE clause
-				if !strings.Contains(strings.ToUpper(query), "WHERE") {
-					return mcp.NewToolResultText("Error: UPDATE queries must include a WHERE clause for safety"), nil
-				}
-
-				result, err := HandleExec(query, StatementTypeUpdate)
+				result, err := HandleExec(ctx, query, StatementTypeUpdate)
 				if err != nil {
-					return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+					return ErrorResult(err), nil
 				}
-				return mcp.NewToolResultText(result), nil
+				return result, nil
 			})
 		}
 
 		if deleteQueryTool.Name != "" {
-			s.AddTool(deleteQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			addTool(deleteQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				query := getStringParam(request, "query", "")
-
-				// Safety check - require WHERE clause
-				if !strings.Contains(strings.ToUpper(query), "WHERE") {
-					return mcp.NewToolResultText("Error: DELETE queries must include a WHERE clause for safety"), nil
-				}
-
-				result, err := HandleExec(query, StatementTypeDelete)
+				result, err := HandleExec(ctx, query, StatementTypeDelete)
 				if err != nil {
-					return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+					return ErrorResult(err), nil
 				}
-				return mcp.NewToolResultText(result), nil
+				return result, nil
 			})
 		}
 
 		if createTableTool.Name != "" {
-			s.AddTool(createTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			addTool(createTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				query := getStringParam(request, "query", "")
-				result, err := HandleExec(query, StatementTypeNoExplainCheck)
+				result, err := HandleExec(ctx, query, StatementTypeCreateTable)
 				if err != nil {
-					return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+					return ErrorResult(err), nil
 				}
-				return mcp.NewToolResultText(result), nil
+				return result, nil
 			})
 		}
 
 		if alterTableTool.Name != "" {
-			s.AddTool(alterTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			addTool(alterTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				query := getStringParam(request, "query", "")
-				result, err := HandleExec(query, StatementTypeNoExplainCheck)
+				result, err := HandleExec(ctx, query, StatementTypeAlterTable)
 				if err != nil {
-					return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+					return ErrorResult(err), nil
 				}
-				return mcp.NewToolResultText(result), nil
+				return result, nil
 			})
 		}
 
 		if createIndexTool.Name != "" {
-			s.AddTool(createIndexTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			addTool(createIndexTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				query := getStringParam(request, "query", "")
-				result, err := HandleExec(query, StatementTypeNoExplainCheck)
+				result, err := HandleExec(ctx, query, StatementTypeCreateIndex)
 				if err != nil {
-					return mcp.NewToolResultText(fmt.Sprintf("Error: %v", err)), nil
+					return ErrorResult(err), nil
 				}
-				return mcp.NewToolResultText(result), nil
+				return result, nil
 			})
 		}
 	}
 
-	// Start server
-	if Transport == "sse" {
-		sseServer := server.NewSSEServer(s, server.WithBaseURL(fmt.Sprintf("http://%s:%d", IPaddress, Port)))
-		log.Printf("SSE server listening on %s:%d", IPaddress, Port)
-		if err := sseServer.Start(fmt.Sprintf("%s:%d", IPaddress, Port)); err != nil {
-			log.Fatalf("Server error: %v", err)
-		}
-	} else {
-		if err := server.ServeStdio(s); err != nil {
-			log.Fatalf("Server error: %v", err)
+	for name := range ToolOverrides {
+		if !offered[name] {
+			log.Printf("Warning: tools.%s does not name a tool offered by this server", name)
 		}
 	}
-}
 
-// Database connection management
-func GetDB() (*sqlx.DB, error) {
-	if DB != nil {
-		return DB, nil
+	RegisterResources(s)
+	if err := RegisterPrompts(s); err != nil {
+		log.Fatalf("Failed to load prompts: %v", err)
 	}
 
-	db, err := sqlx.Connect("pgx", DSN)
-	if err != nil {
-		return nil, fmt.Errorf("failed to establish database connection: %v", err)
+	// Start server
+	if err := Serve(s); err != nil {
+		log.Fatalf("Server error: %v", err)
 	}
-
-	DB = db
-	return DB, nil
 }
 
-// Query execution
-func HandleQuery(query, expect string) (string, error) {
-	result, headers, err := DoQuery(query, expect)
+// HandleTableListQuery runs a catalog query listing tables and drops the rows
+// of tables the read ACL hides
+func HandleTableListQuery(ctx context.Context, query, format, schemaColumn, tableColumn string, args ...interface{}) (*mcp.CallToolResult, error) {
+	start := time.Now()
+	result, columns, err := DoQuery(ctx, query, StatementTypeNoExplainCheck, args...)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return MapToCSV(result, headers)
+	result = FilterTableRows(result, schemaColumn, tableColumn)
+	return QueryToolResult(result, columns, format, time.Since(start), "")
 }
 
-func DoQuery(query, expect string) ([]map[string]interface{}, []string, error) {
-	db, err := GetDB()
+// Query execution
+func HandleQuery(ctx context.Context, query, expect, format string, args ...interface{}) (*mcp.CallToolResult, error) {
+	start := time.Now()
+	result, columns, err := DoQuery(ctx, query, expect, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	if len(expect) > 0 && WithExplainCheck {
-		if err := HandleExplain(query, expect); err != nil {
+	return QueryToolResult(result, columns, format, time.Since(start), "")
+}
+
+func DoQuery(ctx context.Context, query, expect string, args ...interface{}) ([]map[string]interface{}, []Column, error) {
+	if len(expect) > 0 {
+		if err := CheckStatement(ctx, query, expect, args...); err != nil {
 			return nil, nil, err
 		}
 	}
 
-	rows, err := db.Queryx(query)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-
-	cols, err := rows.Columns()
-	if err != nil {
-		return nil, nil, err
-	}
-
 	var result []map[string]interface{}
-	for rows.Next() {
-		row, err := rows.SliceScan()
-		if err != nil {
-			return nil, nil, err
-		}
+	var cols []Column
 
-		resultRow := map[string]interface{}{}
-		for i, col := range cols {
-			switch v := row[i].(type) {
-			case []byte:
-				resultRow[col] = string(v)
-			default:
-				resultRow[col] = v
+	AuditSQL(ctx, query)
+	err := WithConn(ctx, func(conn *sqlx.Conn) error {
+		if IsReadOnly(ctx) {
+			if _, err := conn.ExecContext(ctx, "BEGIN READ ONLY"); err != nil {
+				return err
 			}
+			defer conn.ExecContext(context.Background(), "ROLLBACK")
 		}
-		result = append(result, resultRow)
+
+		var err error
+		result, cols, err = QueryTyped(ctx, conn, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, WrapQueryError(err, query, 0)
 	}
 
 	return result, cols, nil
 }
 
-// Execute write operations
-func HandleExec(query, expect string) (string, error) {
-	db, err := GetDB()
-	if err != nil {
-		return "", err
+// ReadPage runs a SELECT through a server-side cursor and returns its first
+// page of at most limit rows
+func ReadPage(ctx context.Context, query string, limit int) (*Page, error) {
+	if err := CheckStatement(ctx, query, StatementTypeSelect); err != nil {
+		return nil, err
 	}
 
-	if len(expect) > 0 && WithExplainCheck {
-		if err := HandleExplain(query, expect); err != nil {
-			return "", err
+	return OpenCursor(ctx, query, limit)
+}
+
+// Execute write operations
+func HandleExec(ctx context.Context, query, expect string) (*mcp.CallToolResult, error) {
+	if err := CheckWriteAllowed(ctx); err != nil {
+		return nil, err
+	}
+	if len(expect) > 0 {
+		if err := CheckStatement(ctx, query, expect); err != nil {
+			return nil, err
 		}
 	}
 
-	result, err := db.Exec(query)
+	AuditSQL(ctx, query)
+	start := time.Now()
+	var ra int64
+	err := WithConn(ctx, func(conn *sqlx.Conn) error {
+		result, err := conn.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		ra, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
-		return "", err
+		return nil, WrapQueryError(err, query, 0)
 	}
 
-	ra, err := result.RowsAffected()
+	return ExecToolResult(ra, time.Since(start)), nil
+}
+
+// CheckStatement validates query against the expected statement type and,
+// with --with-explain-check, its query plan
+func CheckStatement(ctx context.Context, query, expect string, args ...interface{}) error {
+	stmt, err := ValidateStatement(query, expect)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if err := CheckAccess(ctx, query); err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("%d rows affected", ra), nil
+	if WithExplainCheck && isExplainable(stmt.Type) {
+		return HandleExplain(ctx, query, stmt.Type, args...)
+	}
+	return nil
 }
 
 // EXPLAIN query validation
-func HandleExplain(query, expect string) error {
+func HandleExplain(ctx context.Context, query, expect string, args ...interface{}) error {
 	if !WithExplainCheck {
 		return nil
 	}
 
-	db, err := GetDB()
+	db, err := GetDB(ctx)
 	if err != nil {
 		return err
 	}
 
-	rows, err := db.Queryx(fmt.Sprintf("EXPLAIN %s", query))
+	const prefix = "EXPLAIN (FORMAT JSON, VERBOSE) "
+	var output []byte
+	err = WithConn(ctx, func(conn *sqlx.Conn) error {
+		// Without ANALYZE the statement is only planned, so even writes are
+		// explained inside a READ ONLY transaction
+		if _, err := conn.ExecContext(ctx, "BEGIN READ ONLY"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+		return conn.QueryRowxContext(ctx, prefix+query, args...).Scan(&output)
+	})
+	if err != nil {
+		return WrapQueryError(err, query, len(prefix))
+	}
+
+	plan, err := ParseExplainJSON(output)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	// For PostgreSQL, just check if EXPLAIN works
-	return nil
+	return CheckExplainPlan(ctx, db, plan, expect)
+}
+
+// isExplainable reports whether EXPLAIN accepts statements of the given type
+func isExplainable(statementType string) bool {
+	switch statementType {
+	case StatementTypeSelect, StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete:
+		return true
+	}
+	return false
 }
 
 // CSV output formatting
@@ -610,7 +935,7 @@ func MapToCSV(m []map[string]interface{}, headers []string) (string, error) {
 			if !exists {
 				row[i] = ""
 			} else {
-				row[i] = fmt.Sprintf("%v", value)
+				row[i] = textValue(value)
 			}
 		}
 		if err := writer.Write(row); err != nil {
@@ -626,25 +951,36 @@ func MapToCSV(m []map[string]interface{}, headers []string) (string, error) {
 	return csvBuf.String(), nil
 }
 
+// QuoteIdentifier returns a safely quoted, dot-separated SQL identifier such as
+// "public"."MyTable". Empty parts are skipped.
+func QuoteIdentifier(parts ...string) string {
+	var ident pgx.Identifier
+	for _, part := range parts {
+		if part != "" {
+			ident = append(ident, part)
+		}
+	}
+	return ident.Sanitize()
+}
+
 // Parameter helpers
 func getStringParam(request mcp.CallToolRequest, key, defaultValue string) string {
-	if value, ok := request.Params.Arguments[key].(string); ok {
+	if value, ok := request.GetArguments()[key].(string); ok {
 		return value
 	}
 	return defaultValue
 }
 
 func getNumberParam(request mcp.CallToolRequest, key string, defaultValue float64) float64 {
-	if value, ok := request.Params.Arguments[key].(float64); ok {
+	if value, ok := request.GetArguments()[key].(float64); ok {
 		return value
 	}
 	return defaultValue
 }
 
 func getBoolParam(request mcp.CallToolRequest, key string, defaultValue bool) bool {
-	if value, ok := request.Params.Arguments[key].(bool); ok {
+	if value, ok := request.GetArguments()[key].(bool); ok {
 		return value
 	}
 	return defaultValue
 }
-
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Masking strategies
+const (
+	MaskRedact  = "redact"  // Replace the value with RedactedValue
+	MaskPartial = "partial" // Keep the e-mail domain or the last 4 characters
+	MaskHash    = "hash"    // Keyed SHA-256, equal values still compare equal
+	MaskNull    = "null"    // Return NULL
+)
+
+// RedactedValue replaces values masked with the redact strategy
+const RedactedValue = "[redacted]"
+
+// MaskRule masks the columns matching Pattern, either schema.table.column
+// (matched against the table column a value is read from) or a column name
+// pattern such as *email* (matched against result and source column names)
+type MaskRule struct {
+	Pattern  string
+	Strategy string
+}
+
+var (
+	MaskRules []MaskRule
+	// MaskHashKey keys the hash strategy; a random key is used when empty,
+	// so hashes only stay stable while the server runs
+	MaskHashKey string
+)
+
+// MaskFlag collects repeated --mask pattern=strategy flags
+type MaskFlag []string
+
+func (f *MaskFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *MaskFlag) Set(value string) error {
+	pattern, strategy, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected pattern=strategy, got %q", value)
+	}
+	*f = append(*f, value)
+	return AddMaskRule(strings.TrimSpace(pattern), strings.TrimSpace(strategy))
+}
+
+// AddMaskRule validates and registers a masking rule. Qualified rules take
+// precedence over name patterns, otherwise rules are tried in pattern order.
+func AddMaskRule(pattern, strategy string) error {
+	switch strategy {
+	case MaskRedact, MaskPartial, MaskHash, MaskNull:
+	default:
+		return fmt.Errorf("unknown masking strategy %q for %s (use redact, partial, hash or null)", strategy, pattern)
+	}
+	segments := strings.Split(pattern, ".")
+	if len(segments) != 1 && len(segments) != 3 {
+		return fmt.Errorf("invalid masking pattern %q (use schema.table.column or a column name pattern)", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid masking pattern %q: %v", pattern, err)
+	}
+
+	MaskRules = append(MaskRules, MaskRule{Pattern: pattern, Strategy: strategy})
+	sort.SliceStable(MaskRules, func(i, j int) bool {
+		qi, qj := strings.Contains(MaskRules[i].Pattern, "."), strings.Contains(MaskRules[j].Pattern, ".")
+		if qi != qj {
+			return qi
+		}
+		return MaskRules[i].Pattern < MaskRules[j].Pattern
+	})
+	return nil
+}
+
+// InitMasking prepares the hash key
+func InitMasking() error {
+	if MaskHashKey != "" {
+		AddSecret(MaskHashKey)
+		return nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	MaskHashKey = string(key)
+	return nil
+}
+
+// maskStrategy returns the strategy of the first rule matching col
+func maskStrategy(col Column) string {
+	for _, rule := range MaskRules {
+		if strings.Contains(rule.Pattern, ".") {
+			if col.Table == "" {
+				continue
+			}
+			if ok, _ := path.Match(rule.Pattern, col.Schema+"."+col.Table+"."+col.Source); ok {
+				return rule.Strategy
+			}
+			continue
+		}
+		pattern := strings.ToLower(rule.Pattern)
+		for _, name := range []string{col.Name, col.Source} {
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok && name != "" {
+				return rule.Strategy
+			}
+		}
+	}
+	return ""
+}
+
+// MaskResult applies the masking rules to the rows query returned in place
+// and returns the columns with the types of masked values adjusted. Table
+// columns are matched directly; computed columns are masked when their
+// expression reads a masked column.
+func MaskResult(query string, m []map[string]interface{}, columns []Column) []Column {
+	if len(MaskRules) == 0 {
+		return columns
+	}
+
+	computed, err := computedMasks(query)
+	masked := make([]Column, len(columns))
+	copy(masked, columns)
+	for i, col := range columns {
+		strategy := maskStrategy(col)
+		if strategy == "" && col.Table == "" {
+			if err != nil {
+				strategy = MaskRedact // Fail closed when the sources are unknown
+			} else {
+				strategy = computed.strategy(i, col.Name)
+			}
+		}
+		if strategy == "" {
+			continue
+		}
+		if strategy != MaskNull {
+			masked[i].Type = "text"
+		}
+		masked[i].Nullable = true
+		for _, row := range m {
+			row[col.Name] = MaskValue(row[col.Name], strategy)
+		}
+	}
+	return masked
+}
+
+// MaskValue masks a single value; NULL stays NULL
+func MaskValue(v interface{}, strategy string) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	text := textValue(v)
+	switch strategy {
+	case MaskNull:
+		return nil
+	case MaskPartial:
+		return partialMask(text)
+	case MaskHash:
+		mac := hmac.New(sha256.New, []byte(MaskHashKey))
+		mac.Write([]byte(text))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return RedactedValue
+}
+
+// partialMask keeps the first character and domain of an e-mail address or
+// the last 4 characters of longer values
+func partialMask(text string) string {
+	if local, domain, ok := strings.Cut(text, "@"); ok && local != "" {
+		first, _ := utf8.DecodeRuneInString(local)
+		return string(first) + "***@" + domain
+	}
+
+	n := utf8.RuneCountInString(text)
+	if n < 8 {
+		return strings.Repeat("*", n)
+	}
+	runes := []rune(text)
+	return strings.Repeat("*", n-4) + string(runes[n-4:])
+}
+
+// resultMasks holds the strategies of computed result columns, by position in
+// the select list and by output name
+type resultMasks struct {
+	positions map[int]string
+	names     map[string]string
+}
+
+func (r *resultMasks) strategy(position int, name string) string {
+	if strategy, ok := r.positions[position]; ok {
+		return strategy
+	}
+	return r.names[name]
+}
+
+// computedMasks finds the result columns of query whose expressions read
+// masked columns. Every column reference is checked against the name rules
+// and, since its table may be any of the query, against the qualified rules
+// for every table the query reads. Outputs of subqueries and CTEs carry
+// their strategy to the expressions that use them.
+func computedMasks(query string) (*resultMasks, error) {
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, fmt.Errorf("expected a single statement")
+	}
+	stmt := tree.Stmts[0].Stmt
+	m := newMaskScope(stmt)
+
+	masks := &resultMasks{positions: map[int]string{}, names: m.names}
+	for _, list := range selectLists(stmt.GetSelectStmt()) {
+		for i, item := range list {
+			target := item.GetResTarget()
+			if target == nil || isStar(target.Val) {
+				break // Positions after * depend on the expanded columns
+			}
+			if strategy := m.expression(target.Val); strategy != "" {
+				masks.positions[i] = combineStrategies(masks.positions[i], strategy)
+			}
+		}
+	}
+	return masks, nil
+}
+
+// ReadsMaskedColumns reports whether any part of query, not only its select
+// list, may read a masked column. Unparsable queries are assumed to.
+func ReadsMaskedColumns(query string) bool {
+	if len(MaskRules) == 0 {
+		return false
+	}
+	tree, err := pg_query.Parse(query)
+	if err != nil {
+		return true
+	}
+	for _, raw := range tree.Stmts {
+		if newMaskScope(raw.Stmt).expression(raw.Stmt) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskQueryError hides the message, detail and hint of a PostgreSQL error
+// raised by a query reading masked columns, since they may quote the values
+// being processed, e.g. invalid input syntax for type integer: "<value>".
+// Errors of class 42 are raised while the statement is analyzed, before any
+// row is read, and are kept.
+func MaskQueryError(err error, query string) error {
+	var qerr *QueryError
+	if !errors.As(err, &qerr) || strings.HasPrefix(qerr.Err.Code, "42") || !ReadsMaskedColumns(query) {
+		return err
+	}
+	masked := *qerr.Err
+	masked.Message = Tf("err_masked_message", "%s (message hidden because the query reads masked columns)", SQLStateClass(masked.Code))
+	masked.Detail, masked.Hint = "", ""
+	qerr.Err = &masked
+	return err
+}
+
+// newMaskScope collects the relations of stmt and the strategies of its
+// nested select outputs
+func newMaskScope(stmt *pg_query.Node) *maskScope {
+	m := &maskScope{names: map[string]string{}, relations: map[string]bool{}}
+	var targets []*pg_query.ResTarget
+	walkNodes(stmt.ProtoReflect(), func(node protoreflect.ProtoMessage) bool {
+		switch node := node.(type) {
+		case *pg_query.RangeVar:
+			m.tables = append(m.tables, [2]string{node.Schemaname, node.Relname})
+			m.relations[node.Relname] = true
+			if node.Alias != nil {
+				m.relations[node.Alias.Aliasname] = true
+			}
+		case *pg_query.RangeSubselect:
+			if node.Alias != nil {
+				m.relations[node.Alias.Aliasname] = true
+			}
+		case *pg_query.CommonTableExpr:
+			m.relations[node.Ctename] = true
+		case *pg_query.ResTarget:
+			targets = append(targets, node)
+		}
+		return true
+	})
+
+	// Propagate through nested selects until no output name changes
+	for changed := true; changed; {
+		changed = false
+		for _, target := range targets {
+			if target.Val == nil || isStar(target.Val) {
+				continue
+			}
+			strategy := m.expression(target.Val)
+			name := targetName(target)
+			if strategy != "" && m.names[name] != combineStrategies(m.names[name], strategy) {
+				m.names[name] = combineStrategies(m.names[name], strategy)
+				changed = true
+			}
+		}
+	}
+	return m
+}
+
+// maskScope is what computedMasks knows about the relations of a query
+type maskScope struct {
+	tables    [][2]string       // Schema (possibly empty) and name of every table read
+	relations map[string]bool   // Table, alias, subquery and CTE names
+	names     map[string]string // Strategies of masked nested select outputs
+}
+
+// expression returns the strategy for a value computed by expr, or "" when
+// it reads no masked column
+func (m *maskScope) expression(expr *pg_query.Node) string {
+	if expr == nil {
+		return ""
+	}
+	strategy := ""
+	walkNodes(expr.ProtoReflect(), func(node protoreflect.ProtoMessage) bool {
+		switch node := node.(type) {
+		case *pg_query.ColumnRef:
+			strategy = combineStrategies(strategy, m.columnRef(node))
+		case *pg_query.FuncCall:
+			// Functions reading tables by name bypass column references
+			if n := len(node.Funcname); n > 0 && restrictedFunction(node.Funcname[n-1].GetString_().GetSval()) {
+				strategy = combineStrategies(strategy, MaskRedact)
+			}
+		}
+		return true
+	})
+	return strategy
+}
+
+func (m *maskScope) columnRef(ref *pg_query.ColumnRef) string {
+	var names []string
+	for _, field := range ref.Fields {
+		if field.GetAStar() != nil {
+			return MaskRedact // A whole row may hold any masked column
+		}
+		names = append(names, field.GetString_().GetSval())
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if len(names) == 1 && m.relations[names[0]] {
+		return MaskRedact
+	}
+
+	column := names[len(names)-1]
+	strategy := m.names[column]
+	for _, rule := range MaskRules {
+		if m.ruleMatches(rule, column) {
+			return combineStrategies(strategy, rule.Strategy)
+		}
+	}
+	return strategy
+}
+
+// ruleMatches reports whether rule may cover column of any table the query reads
+func (m *maskScope) ruleMatches(rule MaskRule, column string) bool {
+	segments := strings.Split(rule.Pattern, ".")
+	if len(segments) == 1 {
+		ok, _ := path.Match(strings.ToLower(rule.Pattern), strings.ToLower(column))
+		return ok
+	}
+	for _, table := range m.tables {
+		schema := table[0]
+		if schema == "" {
+			schema = segments[0] // Resolved by search_path, could be any schema
+		}
+		if matchSegments(segments, schema, table[1], column) {
+			return true
+		}
+	}
+	return false
+}
+
+// combineStrategies returns the strategy for a value derived from values
+// masked with a and b; differing strategies fall back to the strictest
+func combineStrategies(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case a == MaskNull || b == MaskNull:
+		return MaskNull
+	}
+	return MaskRedact
+}
+
+// selectLists returns the select lists of stmt, one per branch of a set operation
+func selectLists(stmt *pg_query.SelectStmt) [][]*pg_query.Node {
+	if stmt == nil {
+		return nil
+	}
+	if stmt.Op != pg_query.SetOperation_SETOP_NONE {
+		return append(selectLists(stmt.Larg), selectLists(stmt.Rarg)...)
+	}
+	return [][]*pg_query.Node{stmt.TargetList}
+}
+
+func isStar(expr *pg_query.Node) bool {
+	ref := expr.GetColumnRef()
+	return ref != nil && len(ref.Fields) > 0 && ref.Fields[len(ref.Fields)-1].GetAStar() != nil
+}
+
+// targetName returns the output name PostgreSQL gives a select list item
+func targetName(target *pg_query.ResTarget) string {
+	if target.Name != "" {
+		return target.Name
+	}
+	if cast := target.Val.GetTypeCast(); cast != nil {
+		if name := exprName(cast.Arg); name != "" {
+			return name
+		}
+		if names := cast.TypeName.GetNames(); len(names) > 0 {
+			return names[len(names)-1].GetString_().GetSval()
+		}
+	}
+	if name := exprName(target.Val); name != "" {
+		return name
+	}
+	return "?column?"
+}
+
+func exprName(expr *pg_query.Node) string {
+	switch {
+	case expr.GetColumnRef() != nil:
+		fields := expr.GetColumnRef().Fields
+		return fields[len(fields)-1].GetString_().GetSval()
+	case expr.GetFuncCall() != nil:
+		names := expr.GetFuncCall().Funcname
+		return names[len(names)-1].GetString_().GetSval()
+	case expr.GetCoalesceExpr() != nil:
+		return "coalesce"
+	case expr.GetCaseExpr() != nil:
+		return "case"
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// withMaskRules replaces the masking rules for the duration of a test
+func withMaskRules(t *testing.T, rules ...string) {
+	t.Helper()
+	oldRules, oldKey := MaskRules, MaskHashKey
+	MaskRules, MaskHashKey = nil, "test-key"
+	t.Cleanup(func() { MaskRules, MaskHashKey = oldRules, oldKey })
+	for _, rule := range rules {
+		pattern, strategy, _ := strings.Cut(rule, "=")
+		if err := AddMaskRule(pattern, strategy); err != nil {
+			t.Fatalf("AddMaskRule(%q): %v", rule, err)
+		}
+	}
+}
+
+func TestAddMaskRule(t *testing.T) {
+	withMaskRules(t)
+	tests := []struct {
+		pattern, strategy string
+		wantErr           bool
+	}{
+		{"*email*", MaskPartial, false},
+		{"public.users.ssn", MaskHash, false},
+		{"*.*.token", MaskNull, false},
+		{"users.ssn", MaskRedact, true},
+		{"*email*", "scramble", true},
+		{"[", MaskRedact, true},
+	}
+	for _, tt := range tests {
+		if err := AddMaskRule(tt.pattern, tt.strategy); (err != nil) != tt.wantErr {
+			t.Errorf("AddMaskRule(%q, %q) error = %v, want error %v", tt.pattern, tt.strategy, err, tt.wantErr)
+		}
+	}
+	if MaskRules[0].Pattern != "*.*.token" || MaskRules[1].Pattern != "public.users.ssn" {
+		t.Errorf("qualified rules should sort first, got %v", MaskRules)
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	withMaskRules(t)
+	tests := []struct {
+		value    interface{}
+		strategy string
+		want     interface{}
+	}{
+		{nil, MaskRedact, nil},
+		{"secret", MaskRedact, RedactedValue},
+		{"secret", MaskNull, nil},
+		{"jane.doe@example.com", MaskPartial, "j***@example.com"},
+		{"@example.com", MaskPartial, "********.com"},
+		{"123-45-6789", MaskPartial, "*******6789"},
+		{"short", MaskPartial, "*****"},
+		{"ÄÖÜäöüß€", MaskPartial, "****öüß€"},
+		{int64(4111111111111111), MaskPartial, "************1111"},
+	}
+	for _, tt := range tests {
+		if got := MaskValue(tt.value, tt.strategy); got != tt.want {
+			t.Errorf("MaskValue(%v, %s) = %v, want %v", tt.value, tt.strategy, got, tt.want)
+		}
+	}
+
+	a, b := MaskValue("123-45-6789", MaskHash), MaskValue("123-45-6789", MaskHash)
+	if a != b || !strings.HasPrefix(a.(string), "hash:") || strings.Contains(a.(string), "6789") {
+		t.Errorf("MaskValue(hash) = %v and %v, want equal hashes without the value", a, b)
+	}
+	if MaskValue("123-45-6780", MaskHash) == a {
+		t.Error("different values hash equally")
+	}
+}
+
+func TestMaskResult(t *testing.T) {
+	withMaskRules(t, "*email*=partial", "public.users.ssn=hash", "*.*.token=null")
+	tests := []struct {
+		name    string
+		query   string
+		columns []Column
+		masked  []bool
+	}{
+		{"table columns", "SELECT id, email FROM public.users",
+			[]Column{{Name: "id", Schema: "public", Table: "users", Source: "id"}, {Name: "email", Schema: "public", Table: "users", Source: "email"}},
+			[]bool{false, true}},
+		{"aliased table column", "SELECT ssn AS x FROM public.users",
+			[]Column{{Name: "x", Schema: "public", Table: "users", Source: "ssn"}},
+			[]bool{true}},
+		{"function of a masked column", "SELECT lower(email) FROM users",
+			[]Column{{Name: "lower"}},
+			[]bool{true}},
+		{"concatenation", "SELECT email || '' AS x FROM users",
+			[]Column{{Name: "x"}},
+			[]bool{true}},
+		{"qualified rule on a computed column", "SELECT upper(u.ssn) AS s, count(*) AS n FROM public.users u GROUP BY 1",
+			[]Column{{Name: "s"}, {Name: "n"}},
+			[]bool{true, false}},
+		{"unqualified table", "SELECT md5(ssn) FROM users",
+			[]Column{{Name: "md5"}},
+			[]bool{true}},
+		{"other table", "SELECT md5(ssn) FROM public.orders",
+			[]Column{{Name: "md5"}},
+			[]bool{false}},
+		{"subquery output", "SELECT x || '!' AS y FROM (SELECT lower(email) AS x FROM users) s",
+			[]Column{{Name: "y"}},
+			[]bool{true}},
+		{"star over a computed subquery", "SELECT * FROM (SELECT trim(email) AS e, 1 AS one FROM users) s",
+			[]Column{{Name: "e"}, {Name: "one"}},
+			[]bool{true, false}},
+		{"cte", "WITH t AS (SELECT token AS k FROM auth.sessions) SELECT concat(k) FROM t",
+			[]Column{{Name: "concat"}},
+			[]bool{true}},
+		{"union", "SELECT name FROM public.orders UNION ALL SELECT lower(email) FROM users",
+			[]Column{{Name: "name"}},
+			[]bool{true}},
+		{"whole row", "SELECT row_to_json(u) FROM users u",
+			[]Column{{Name: "row_to_json"}},
+			[]bool{true}},
+		{"table reading function", "SELECT query_to_xml('select email from users', true, false, '')",
+			[]Column{{Name: "query_to_xml"}},
+			[]bool{true}},
+		{"unrelated expression", "SELECT id + 1 FROM users",
+			[]Column{{Name: "?column?"}},
+			[]bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := map[string]interface{}{}
+			for _, col := range tt.columns {
+				row[col.Name] = "jane@example.com"
+			}
+			MaskResult(tt.query, []map[string]interface{}{row}, tt.columns)
+			for i, col := range tt.columns {
+				if masked := row[col.Name] != "jane@example.com"; masked != tt.masked[i] {
+					t.Errorf("column %s masked = %v, want %v (value %v)", col.Name, masked, tt.masked[i], row[col.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestMaskResultStrategies(t *testing.T) {
+	withMaskRules(t, "*email*=partial", "public.users.ssn=hash")
+	row := map[string]interface{}{"a": "jane@example.com", "b": "jane@example.com123-45-6789"}
+	columns := MaskResult("SELECT lower(email) AS a, email || ssn AS b FROM public.users", []map[string]interface{}{row}, []Column{{Name: "a"}, {Name: "b"}})
+	if row["a"] != "j***@example.com" {
+		t.Errorf("a = %v, want the partial mask", row["a"])
+	}
+	// Mixing strategies must not reveal the tail the partial mask would keep
+	if row["b"] != RedactedValue {
+		t.Errorf("b = %v, want %s", row["b"], RedactedValue)
+	}
+	if columns[0].Type != "text" || !columns[0].Nullable {
+		t.Errorf("masked column = %+v, want nullable text", columns[0])
+	}
+}
+
+func TestReadsMaskedColumns(t *testing.T) {
+	withMaskRules(t, "*email*=partial", "public.users.ssn=hash")
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT id FROM public.users", false},
+		{"SELECT ssn::int FROM public.users", true},
+		{"SELECT id FROM public.users WHERE ssn::int > 0", true},
+		{"SELECT count(*) FROM public.users WHERE lower(email) = 'x'", true},
+		{"SELECT id FROM public.orders WHERE ssn::int > 0", false},
+		{"EXPLAIN ANALYZE SELECT id FROM users WHERE ssn::int > 0", true},
+		{"SELECT * FROM public.orders", true},
+		{"UPDATE public.users SET id = ssn::int WHERE id = 1", true},
+		{"SELEC", true},
+	}
+	for _, tt := range tests {
+		if got := ReadsMaskedColumns(tt.query); got != tt.want {
+			t.Errorf("ReadsMaskedColumns(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMaskQueryError(t *testing.T) {
+	withMaskRules(t, "public.users.ssn=redact")
+	tests := []struct {
+		query, code string
+		hidden      bool
+	}{
+		{"SELECT ssn::int FROM public.users", "22P02", true},
+		{"SELECT id FROM public.users WHERE ssn::int > 0", "22P02", true},
+		{"SELECT id::int FROM public.users", "22P02", false},
+		{"SELECT ssn FROM public.users WHERE nme = 1", "42703", false},
+	}
+	for _, tt := range tests {
+		pgErr := &pgconn.PgError{Severity: "ERROR", Code: tt.code, Message: `invalid input syntax for type integer: "123-45-6789"`, Detail: "Key (ssn)=(123-45-6789)", Hint: "123-45-6789"}
+		err := WrapQueryError(pgErr, tt.query, 0)
+		info := err.(*QueryError).Info()
+		leaked := strings.Contains(err.Error(), "6789") || strings.Contains(info.Message+info.Detail+info.Hint, "6789")
+		if leaked == tt.hidden {
+			t.Errorf("WrapQueryError(%q, %s) = %q, want values hidden %v", tt.query, tt.code, err, tt.hidden)
+		}
+		if info.Code != tt.code {
+			t.Errorf("code = %s, want %s", info.Code, tt.code)
+		}
+	}
+	if pgErr := (&pgconn.PgError{Code: "22P02", Message: "x"}); MaskQueryError(pgErr, "SELECT ssn FROM public.users") != pgErr {
+		t.Error("MaskQueryError changed an error without a query")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// maxOpenCursors bounds how many pool connections paginated reads may pin
+const maxOpenCursors = 16
+
+// Page is one batch of rows read through a server-side cursor
+type Page struct {
+	Rows    []map[string]interface{}
+	Columns []Column
+	Cursor  string // Continuation token, empty when the result is exhausted
+	Query   string // The SELECT the rows come from
+}
+
+// resultCursor keeps a transaction with an open SQL cursor on a dedicated
+// connection until the client has fetched every page or it sits idle too long
+type resultCursor struct {
+	mu        sync.Mutex
+	db        *sqlx.DB // Pool the connection came from, used to cancel statements
+	conn      *sqlx.Conn
+	query     string // Statement the cursor reads, for the audit log
+	owner     string // Session and identity that opened the cursor
+	columns   []Column
+	lookahead map[string]interface{}
+	timer     *time.Timer
+	closed    bool
+}
+
+var (
+	cursorsMu sync.Mutex
+	cursors   = map[string]*resultCursor{}
+)
+
+// OpenCursor declares a cursor for query and returns its first page of at most limit rows
+func OpenCursor(ctx context.Context, query string, limit int) (*Page, error) {
+	// Leave at least half of a bounded pool to regular queries
+	maxCursors := maxOpenCursors
+	if MaxOpenConns > 0 && MaxOpenConns/2 < maxCursors {
+		maxCursors = max(MaxOpenConns/2, 1)
+	}
+
+	token, err := newCursorToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Registering the cursor before its connection is taken reserves the slot;
+	// the token is only handed out once a second page exists
+	c := &resultCursor{query: query, owner: cursorOwner(ctx)}
+	cursorsMu.Lock()
+	if len(cursors) >= maxCursors {
+		cursorsMu.Unlock()
+		return nil, Errorf("err_too_many_cursors", "too many open result cursors; fetch their remaining pages or wait %s for them to expire", CursorIdleTimeout)
+	}
+	cursors[token] = c
+	cursorsMu.Unlock()
+
+	db, err := GetDB(ctx)
+	if err != nil {
+		c.forget(token)
+		return nil, err
+	}
+	c.db = db
+	c.conn, err = db.Connx(ctx)
+	if err != nil {
+		c.forget(token)
+		return nil, err
+	}
+
+	// The transaction outlives this call and is ended by close
+	begin := "BEGIN"
+	if IsReadOnly(ctx) {
+		begin = "BEGIN READ ONLY"
+	}
+	if _, err := c.conn.ExecContext(ctx, begin); err != nil {
+		c.forget(token)
+		return nil, err
+	}
+
+	const declare = "DECLARE mcp_cursor NO SCROLL CURSOR FOR "
+	AuditSQL(ctx, query)
+	if err := c.run(ctx, db, declare+query); err != nil {
+		c.forget(token)
+		return nil, WrapQueryError(err, query, len(declare))
+	}
+
+	return c.fetch(ctx, db, limit, token)
+}
+
+// FetchCursor returns the next page of at most limit rows for a continuation
+// token. Only the session and identity that opened the cursor may read it.
+func FetchCursor(ctx context.Context, token string, limit int) (*Page, error) {
+	cursorsMu.Lock()
+	c, ok := cursors[token]
+	cursorsMu.Unlock()
+	if !ok || c.owner != cursorOwner(ctx) {
+		return nil, Errorf("err_cursor_not_found", "cursor not found or expired, run the query again")
+	}
+
+	// The cursor belongs to the connection it was opened on, whatever this call names
+	AuditSQL(ctx, c.query)
+	return c.fetch(ctx, c.db, limit, token)
+}
+
+// run executes a statement inside the cursor transaction under the call's timeout
+func (c *resultCursor) run(ctx context.Context, db *sqlx.DB, statement string) error {
+	release, err := GuardStatement(ctx, db, c.conn, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = c.conn.ExecContext(ctx, statement)
+	return err
+}
+
+func (c *resultCursor) fetch(ctx context.Context, db *sqlx.DB, limit int, token string) (*Page, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, Errorf("err_cursor_not_found", "cursor not found or expired, run the query again")
+	}
+
+	release, err := GuardStatement(ctx, db, c.conn, true)
+	if err != nil {
+		if c.timer == nil {
+			c.forget(token) // Its token was never handed out
+		}
+		return nil, err
+	}
+
+	// Keep one row beyond the page to learn whether another page exists
+	want := limit + 1
+	if c.lookahead != nil {
+		want = limit
+	}
+	result, columns, err := QueryTyped(ctx, c.conn, fmt.Sprintf("FETCH FORWARD %d FROM mcp_cursor", want))
+	release()
+	if err != nil {
+		c.forget(token)
+		return nil, MaskQueryError(WrapQueryError(err, "", 0), c.query)
+	}
+
+	if c.columns == nil {
+		c.columns = columns
+	}
+	if c.lookahead != nil {
+		result = append([]map[string]interface{}{c.lookahead}, result...)
+		c.lookahead = nil
+	}
+
+	page := &Page{Rows: result, Columns: c.columns, Query: c.query}
+	if len(result) <= limit {
+		c.forget(token)
+		return page, nil
+	}
+
+	c.lookahead = result[limit]
+	page.Rows = result[:limit]
+
+	if c.timer == nil {
+		c.timer = time.AfterFunc(CursorIdleTimeout, func() { c.expire(token) })
+	} else {
+		c.timer.Reset(CursorIdleTimeout)
+	}
+
+	page.Cursor = token
+	return page, nil
+}
+
+// forget unregisters the cursor and releases its connection; the caller holds
+// c.mu or is still opening the cursor
+func (c *resultCursor) forget(token string) {
+	cursorsMu.Lock()
+	delete(cursors, token)
+	cursorsMu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.close()
+}
+
+func (c *resultCursor) expire(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forget(token)
+}
+
+func (c *resultCursor) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.conn == nil {
+		return
+	}
+	c.conn.ExecContext(context.Background(), "ROLLBACK")
+	c.conn.Close()
+}
+
+// cursorOwner identifies who may fetch the pages of a cursor opened with ctx.
+// Without --http-stateful clients choose their session IDs, so there the
+// authenticated identity is what keeps cursors apart.
+func cursorOwner(ctx context.Context) string {
+	owner := sessionID(ctx)
+	if identity := IdentityFromContext(ctx); identity != nil {
+		owner += "/" + identity.Method + ":" + identity.Name
+	}
+	return owner
+}
+
+func newCursorToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// withCursors replaces the open cursors for the duration of a test
+func withCursors(t *testing.T, open map[string]*resultCursor) {
+	t.Helper()
+	cursorsMu.Lock()
+	old := cursors
+	cursors = open
+	cursorsMu.Unlock()
+	t.Cleanup(func() {
+		cursorsMu.Lock()
+		cursors = old
+		cursorsMu.Unlock()
+	})
+}
+
+func TestCursorOwner(t *testing.T) {
+	alice := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "alice", Method: "jwt"})
+	bob := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "bob", Method: "jwt"})
+	aliceToken := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "alice", Method: "token"})
+
+	owners := map[string]bool{}
+	for _, ctx := range []context.Context{context.Background(), alice, bob, aliceToken} {
+		owners[cursorOwner(ctx)] = true
+	}
+	if len(owners) != 4 {
+		t.Errorf("cursorOwner does not tell the callers apart: %v", owners)
+	}
+	if cursorOwner(alice) != cursorOwner(context.WithValue(context.Background(), identityKey{}, &Identity{Name: "alice", Method: "jwt"})) {
+		t.Error("cursorOwner differs for the same caller")
+	}
+}
+
+func TestFetchCursorOwner(t *testing.T) {
+	alice := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "alice", Method: "jwt"})
+	bob := context.WithValue(context.Background(), identityKey{}, &Identity{Name: "bob", Method: "jwt"})
+	c := &resultCursor{query: "SELECT 1", owner: cursorOwner(alice)}
+	withCursors(t, map[string]*resultCursor{"t1": c})
+
+	if _, err := FetchCursor(bob, "t1", 10); err == nil || !strings.Contains(err.Error(), "cursor not found") {
+		t.Errorf("FetchCursor by another identity = %v, want err_cursor_not_found", err)
+	}
+	if _, err := FetchCursor(context.Background(), "t1", 10); err == nil {
+		t.Error("FetchCursor without identity read a cursor of alice")
+	}
+	if _, ok := cursors["t1"]; !ok {
+		t.Error("a rejected fetch closed the cursor of its owner")
+	}
+}
+
+func TestOpenCursorLimit(t *testing.T) {
+	oldMax := MaxOpenConns
+	MaxOpenConns = 0
+	t.Cleanup(func() { MaxOpenConns = oldMax })
+
+	open := map[string]*resultCursor{}
+	for i := 0; i < maxOpenCursors; i++ {
+		open[fmt.Sprint(i)] = &resultCursor{closed: true}
+	}
+	withCursors(t, open)
+
+	if _, err := OpenCursor(context.Background(), "SELECT 1", 10); err == nil || !strings.Contains(err.Error(), "too many open result cursors") {
+		t.Errorf("OpenCursor over the limit = %v, want err_too_many_cursors", err)
+	}
+
+	// A failed open releases the slot it reserved
+	delete(open, "0")
+	if _, err := OpenCursor(context.Background(), "SELECT 1", 10); err == nil {
+		t.Fatal("OpenCursor without a connection succeeded")
+	}
+	if len(cursors) != maxOpenCursors-1 {
+		t.Errorf("%d cursors registered after a failed open, want %d", len(cursors), maxOpenCursors-1)
+	}
+}
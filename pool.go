@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Connection pool settings. Zero means unlimited, except for MaxIdleConns
+// where it keeps no idle connections at all.
+var (
+	MaxOpenConns        int
+	MaxIdleConns        int
+	ConnMaxLifetime     time.Duration
+	ConnMaxIdleTime     time.Duration
+	HealthCheckInterval time.Duration
+)
+
+// ValidatePoolConfig rejects pool settings database/sql would silently misread
+func ValidatePoolConfig() error {
+	switch {
+	case MaxOpenConns < 0:
+		return fmt.Errorf("--max-open-conns must not be negative")
+	case MaxIdleConns < 0:
+		return fmt.Errorf("--max-idle-conns must not be negative")
+	case MaxOpenConns > 0 && MaxIdleConns > MaxOpenConns:
+		return fmt.Errorf("--max-idle-conns (%d) must not exceed --max-open-conns (%d)", MaxIdleConns, MaxOpenConns)
+	case ConnMaxLifetime < 0, ConnMaxIdleTime < 0, HealthCheckInterval < 0:
+		return fmt.Errorf("connection durations must not be negative")
+	}
+	return nil
+}
+
+// ConfigurePool applies the pool settings to db
+func ConfigurePool(db *sqlx.DB) {
+	db.SetMaxOpenConns(MaxOpenConns)
+	db.SetMaxIdleConns(MaxIdleConns)
+	db.SetConnMaxLifetime(ConnMaxLifetime)
+	db.SetConnMaxIdleTime(ConnMaxIdleTime)
+}
+
+// MonitorHealth pings db every --health-check-interval and drops the idle
+// connections when a ping fails, so none survives a restart or failover.
+func MonitorHealth(db *sqlx.DB) {
+	if HealthCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	healthy := true
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.PingContext(ctx)
+		cancel()
+
+		switch {
+		case err != nil:
+			if healthy {
+				log.Printf("Database health check failed: %v", err)
+			}
+			healthy = false
+			db.SetMaxIdleConns(0)
+			db.SetMaxIdleConns(MaxIdleConns)
+		case !healthy:
+			log.Printf("Database connection restored")
+			healthy = true
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/pelletier/go-toml/v2"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// PromptsDir holds user-defined prompt files (*.toml)
+var PromptsDir string
+
+// maxPromptTables bounds the tables listed in prompt context
+const maxPromptTables = 200
+
+// PromptDefinition is a prompt rendered from a text/template. Arguments are
+// available as {{.name}}; functions such as {{table .schema .table}} insert
+// live schema context.
+type PromptDefinition struct {
+	Name        string           `toml:"name"`
+	Description string           `toml:"description"`
+	Arguments   []PromptArgument `toml:"arguments"`
+	Template    string           `toml:"template"`
+}
+
+// PromptArgument is an argument of a PromptDefinition
+type PromptArgument struct {
+	Name        string `toml:"name"`
+	Description string `toml:"description"`
+	Required    bool   `toml:"required"`
+}
+
+var schemaArgument = PromptArgument{Name: "schema", Description: "Schema name (default: all schemas)"}
+
+// BuiltinPrompts are offered unless a prompt file redefines them
+var BuiltinPrompts = []PromptDefinition{
+	{
+		Name:        "explore_schema",
+		Description: "Get an overview of the data model of a database or schema",
+		Arguments:   []PromptArgument{schemaArgument},
+		Template: `I want to understand the data model of the database on connection {{.connection}}{{with .schema}}, schema {{.}}{{end}}. These are its tables with their estimated row counts:
+
+{{tables .schema}}
+Summarize what the data is about, group the tables by purpose, point out the central tables and how they relate, and suggest queries worth running next. Use the describe_table, list_indexes and read_query tools to look closer where needed.`,
+	},
+	{
+		Name:        "safe_migration",
+		Description: "Write a migration for a table that avoids long locks and can be rolled back",
+		Arguments: []PromptArgument{
+			{Name: "table", Description: "Table to change", Required: true},
+			{Name: "change", Description: "The change to make, e.g. \"add a non-null status column\"", Required: true},
+			{Name: "schema", Description: "Schema of the table (default: public)"},
+		},
+		Template: `{{$schema := or .schema "public"}}Write a migration for the table {{$schema}}.{{.table}} on connection {{.connection}}: {{.change}}
+
+Current definition:
+
+` + "```sql" + `
+{{table $schema .table}}` + "```" + `
+
+Foreign keys from and to the table:
+
+{{relationships $schema .table}}
+The migration runs against a live database. Avoid statements that rewrite the table or hold ACCESS EXCLUSIVE locks for long: add columns without volatile defaults, create indexes CONCURRENTLY, add constraints NOT VALID and validate them separately, and backfill large tables in batches. Start with SET lock_timeout, point out every step that still blocks reads or writes, and provide a rollback script.`,
+	},
+	{
+		Name:        "diagnose_slow_query",
+		Description: "Find out why a query is slow from its plan and the tables it reads",
+		Arguments: []PromptArgument{
+			{Name: "query", Description: "The slow query", Required: true},
+		},
+		Template: `This query is slow on connection {{.connection}}:
+
+` + "```sql" + `
+{{.query}}
+` + "```" + `
+
+Its estimated plan:
+
+` + "```" + `
+{{explain .query}}` + "```" + `
+
+Definitions of the tables it reads:
+
+` + "```sql" + `
+{{queryTables .query}}` + "```" + `
+
+Explain where the time is likely spent, comparing the estimates with the table sizes. Suggest indexes, query rewrites or statistics changes and how each would change the plan. Only use explain_query with analyze on statements that do not modify data.`,
+	},
+	{
+		Name:        "table_relationships",
+		Description: "Explain how a table relates to the rest of the schema",
+		Arguments: []PromptArgument{
+			{Name: "table", Description: "Table name", Required: true},
+			{Name: "schema", Description: "Schema of the table (default: public)"},
+		},
+		Template: `{{$schema := or .schema "public"}}Explain the relationships of the table {{$schema}}.{{.table}} on connection {{.connection}}.
+
+` + "```sql" + `
+{{table $schema .table}}` + "```" + `
+
+Foreign keys from and to the table:
+
+{{relationships $schema .table}}
+For each relationship describe what it means for the data, its cardinality, which side is optional and what happens on delete or update. Show example JOINs, and point out columns that look like references but have no foreign key.`,
+	},
+	{
+		Name:        "report_query",
+		Description: "Write a read-only reporting query answering a question",
+		Arguments: []PromptArgument{
+			{Name: "question", Description: "What the report should answer", Required: true},
+			schemaArgument,
+		},
+		Template: `Write a read-only SQL query on connection {{.connection}} that answers: {{.question}}
+
+Available tables and columns:
+
+{{columns .schema}}
+Use only these tables and columns. Write a single SELECT with descriptive column aliases, aggregate in the database, and order the result meaningfully. Then run it with read_query and summarize the answer.`,
+	},
+}
+
+// RegisterPrompts adds the built-in prompts and those of PromptsDir. A prompt
+// file may replace a built-in prompt by using its name.
+func RegisterPrompts(s *server.MCPServer) error {
+	var definitions []PromptDefinition
+	for _, definition := range BuiltinPrompts {
+		definitions = append(definitions, localizePrompt(definition))
+	}
+	if PromptsDir != "" {
+		files, err := LoadPromptFiles(PromptsDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			replaced := false
+			for i := range definitions {
+				if definitions[i].Name == file.Name {
+					definitions[i], replaced = file, true
+				}
+			}
+			if !replaced {
+				definitions = append(definitions, file)
+			}
+		}
+	}
+
+	for _, definition := range definitions {
+		prompt, handler, err := NewPromptHandler(definition)
+		if err != nil {
+			return err
+		}
+		s.AddPrompt(prompt, handler)
+	}
+	return nil
+}
+
+// localizePrompt translates the descriptions of a built-in prompt to --lang.
+// Prompt files keep the descriptions they were written with.
+func localizePrompt(definition PromptDefinition) PromptDefinition {
+	definition.Description = T("prompt_"+definition.Name, definition.Description)
+	arguments := make([]PromptArgument, len(definition.Arguments))
+	for i, arg := range definition.Arguments {
+		arg.Description = T("prompt_"+definition.Name+"_"+arg.Name, arg.Description)
+		arguments[i] = arg
+	}
+	definition.Arguments = arguments
+	return definition
+}
+
+// LoadPromptFiles reads every *.toml prompt definition in dir. The file name
+// is the prompt name unless the file sets one.
+func LoadPromptFiles(dir string) ([]PromptDefinition, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions []PromptDefinition
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt file: %v", err)
+		}
+		var definition PromptDefinition
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&definition); err != nil {
+			return nil, fmt.Errorf("failed to parse prompt file %s: %v", path, err)
+		}
+		if definition.Name == "" {
+			definition.Name = strings.TrimSuffix(filepath.Base(path), ".toml")
+		}
+		if definition.Template == "" {
+			return nil, fmt.Errorf("prompt file %s has no template", path)
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+// NewPromptHandler parses the template of definition and returns the prompt
+// with a handler rendering it. Every prompt takes a connection argument.
+func NewPromptHandler(definition PromptDefinition) (mcp.Prompt, server.PromptHandlerFunc, error) {
+	tmpl, err := template.New(definition.Name).Option("missingkey=zero").Funcs(promptFuncs(context.Background())).Parse(definition.Template)
+	if err != nil {
+		return mcp.Prompt{}, nil, fmt.Errorf("prompt %s: %v", definition.Name, err)
+	}
+
+	options := []mcp.PromptOption{mcp.WithPromptDescription(definition.Description)}
+	for _, arg := range definition.Arguments {
+		argOptions := []mcp.ArgumentOption{mcp.ArgumentDescription(arg.Description)}
+		if arg.Required {
+			argOptions = append(argOptions, mcp.RequiredArgument())
+		}
+		options = append(options, mcp.WithArgument(arg.Name, argOptions...))
+	}
+	options = append(options, mcp.WithArgument("connection", mcp.ArgumentDescription(T("param_connection", "Name of the connection to use (optional, see list_connections)"))))
+
+	handler := func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		data := map[string]string{}
+		for key, value := range request.Params.Arguments {
+			data[key] = value
+		}
+		for _, arg := range definition.Arguments {
+			if arg.Required && data[arg.Name] == "" {
+				return nil, Errorf("err_argument_required", "argument %q is required", arg.Name)
+			}
+		}
+		if data["connection"] == "" {
+			data["connection"] = DefaultConnection
+		}
+		if _, ok := connections[data["connection"]]; !ok {
+			return nil, Errorf("err_unknown_connection", "unknown connection %q (available: %s)", data["connection"], strings.Join(ConnectionNames(), ", "))
+		}
+
+		ctx, cancel := resourceContext(ctx, data["connection"])
+		defer cancel()
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		var text strings.Builder
+		if err := clone.Funcs(promptFuncs(ctx)).Execute(&text, data); err != nil {
+			return nil, fmt.Errorf("prompt %s: %s", definition.Name, Redact(err.Error()))
+		}
+
+		return mcp.NewGetPromptResult(definition.Description, []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text.String())),
+		}), nil
+	}
+	return mcp.NewPrompt(definition.Name, options...), handler, nil
+}
+
+// promptFuncs are the template functions fetching schema context on the
+// connection of ctx
+func promptFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"tables":  func(schema string) (string, error) { return promptTables(ctx, schema) },
+		"columns": func(schema string) (string, error) { return promptColumns(ctx, schema) },
+		"table":   func(schema, table string) (string, error) { return promptTable(ctx, schema, table) },
+		"relationships": func(schema, table string) (string, error) {
+			return promptRelationships(ctx, schema, table)
+		},
+		"explain":     func(query string) (string, error) { return promptExplain(ctx, query) },
+		"queryTables": func(query string) (string, error) { return promptQueryTables(ctx, query) },
+	}
+}
+
+// promptTables lists the readable tables of schema, or of all user schemas
+func promptTables(ctx context.Context, schema string) (string, error) {
+	rows, _, err := DoQuery(ctx, `
+		SELECT n.nspname AS schema, c.relname AS name, c.relkind::text AS kind,
+			CASE WHEN c.reltuples < 0 THEN NULL ELSE c.reltuples::bigint END AS row_estimate
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname NOT LIKE 'pg_toast%'
+			AND ($1 = '' OR n.nspname = $1)
+		ORDER BY n.nspname, c.relname`, StatementTypeNoExplainCheck, schema)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	listed := 0
+	for _, row := range FilterTableRows(rows, "schema", "name") {
+		if listed == maxPromptTables {
+			b.WriteString("- ... more tables, use list_tables to see them all\n")
+			break
+		}
+		fmt.Fprintf(&b, "- %s.%s (%s", row["schema"], row["name"], relationKinds[row["kind"].(string)])
+		if estimate, ok := row["row_estimate"].(int64); ok {
+			fmt.Fprintf(&b, ", about %d rows", estimate)
+		}
+		b.WriteString(")\n")
+		listed++
+	}
+	if listed == 0 {
+		b.WriteString("(no tables)\n")
+	}
+	return b.String(), nil
+}
+
+// promptColumns lists the readable tables of schema with their columns
+func promptColumns(ctx context.Context, schema string) (string, error) {
+	rows, _, err := DoQuery(ctx, `
+		SELECT n.nspname AS schema, c.relname AS name,
+			string_agg(quote_ident(a.attname) || ' ' || format_type(a.atttypid, a.atttypmod), ', ' ORDER BY a.attnum) AS columns
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname NOT LIKE 'pg_toast%'
+			AND ($1 = '' OR n.nspname = $1)
+		GROUP BY n.nspname, c.relname
+		ORDER BY n.nspname, c.relname`, StatementTypeNoExplainCheck, schema)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	listed := 0
+	for _, row := range FilterTableRows(rows, "schema", "name") {
+		if listed == maxPromptTables {
+			b.WriteString("- ... more tables, use list_tables and list_columns to see them all\n")
+			break
+		}
+		fmt.Fprintf(&b, "- %s.%s(%s)\n", row["schema"], row["name"], row["columns"])
+		listed++
+	}
+	if listed == 0 {
+		b.WriteString("(no tables)\n")
+	}
+	return b.String(), nil
+}
+
+// promptTable returns the DDL of a table, preceded by its row estimate
+func promptTable(ctx context.Context, schema, table string) (string, error) {
+	description, err := DescribeTable(ctx, schema, table)
+	if err != nil {
+		return "", err
+	}
+	ddl := description["ddl"].(string)
+	if estimate, ok := description["row_estimate"].(int64); ok {
+		ddl = fmt.Sprintf("-- About %d rows\n%s", estimate, ddl)
+	}
+	return ddl, nil
+}
+
+// promptRelationships lists the foreign keys from and to a table
+func promptRelationships(ctx context.Context, schema, table string) (string, error) {
+	if err := CheckTableAccess(AccessRead, schema, table); err != nil {
+		return "", err
+	}
+	rows, _, err := DoQuery(ctx, `
+		SELECT k.conname AS name, fn.nspname AS from_schema, f.relname AS from_table,
+			tn.nspname AS to_schema, t.relname AS to_table, pg_get_constraintdef(k.oid, true) AS definition
+		FROM pg_constraint k
+		JOIN pg_class f ON f.oid = k.conrelid
+		JOIN pg_namespace fn ON fn.oid = f.relnamespace
+		JOIN pg_class t ON t.oid = k.confrelid
+		JOIN pg_namespace tn ON tn.oid = t.relnamespace
+		WHERE k.contype = 'f' AND to_regclass($1) IN (k.conrelid, k.confrelid)
+		ORDER BY k.conname`, StatementTypeNoExplainCheck, QuoteIdentifier(schema, table))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	rows = FilterTableRows(FilterTableRows(rows, "from_schema", "from_table"), "to_schema", "to_table")
+	for _, row := range rows {
+		fmt.Fprintf(&b, "- %s.%s -> %s.%s: %s %s\n", row["from_schema"], row["from_table"], row["to_schema"], row["to_table"], row["name"], row["definition"])
+	}
+	if len(rows) == 0 {
+		b.WriteString("(none)\n")
+	}
+	return b.String(), nil
+}
+
+// promptExplain returns the estimated plan of a statement, which is checked
+// like explain_query checks it but never executed. It is refused when the
+// explain_query tool is disabled.
+func promptExplain(ctx context.Context, query string) (string, error) {
+	if !ToolEnabled("explain_query") {
+		return "", Errorf("err_prompt_tool_disabled", "this prompt needs the %s tool, which is disabled", "explain_query")
+	}
+	stmt, err := ClassifyStatement(query)
+	if err != nil {
+		return "", err
+	}
+	if !isExplainable(stmt.Type) {
+		return "", Errorf("err_not_explainable", "%s statements cannot be explained", stmt.Type)
+	}
+	if err := CheckAccess(ctx, query); err != nil {
+		return "", err
+	}
+
+	rows, _, err := DoQuery(ReadOnlyContext(ctx), "EXPLAIN "+query, StatementTypeNoExplainCheck)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%s\n", row["QUERY PLAN"])
+	}
+	return b.String(), nil
+}
+
+// promptQueryTables returns the DDL of every table a query references
+func promptQueryTables(ctx context.Context, query string) (string, error) {
+	summary, err := pg_query.Summary(query, -1)
+	if err != nil {
+		return "", Errorf("err_parse_query", "failed to parse query: %v", err)
+	}
+	var tables []*aclTable
+	for _, t := range summary.Tables {
+		tables = append(tables, &aclTable{name: t.Name, schema: t.SchemaName, table: t.TableName})
+	}
+	if tables, err = resolveSchemas(ctx, tables); err != nil {
+		return "", err
+	}
+
+	var ddl []string
+	seen := map[string]bool{}
+	for _, t := range tables {
+		key := t.schema + "." + t.table
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		text, err := promptTable(ctx, t.schema, t.table)
+		if err != nil {
+			return "", err
+		}
+		ddl = append(ddl, text)
+	}
+	return strings.Join(ddl, "\n"), nil
+}
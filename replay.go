@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HTTPReplayWindow is how long the events of a dropped Streamable HTTP stream
+// can be replayed to a client reconnecting with Last-Event-ID
+var HTTPReplayWindow time.Duration
+
+const (
+	// maxReplayEvents bounds the events kept per stream; older ones are lost
+	maxReplayEvents = 1000
+	// maxReplayStreams bounds the streams kept; more streams are sent without
+	// event IDs and cannot be resumed
+	maxReplayStreams = 4096
+)
+
+// replayStream keeps the recent events of one SSE response
+type replayStream struct {
+	mu     sync.Mutex
+	owner  string // Session and identity that opened the stream
+	listen bool   // A GET stream, which goes on with new events after a replay
+	events []replayEvent
+	next   int
+	live   bool          // A response is writing to the stream
+	done   chan struct{} // Closed when the response writing it ends
+	timer  *time.Timer
+}
+
+type replayEvent struct {
+	seq  int
+	data []byte // The event as the MCP library wrote it, without an id line
+}
+
+var (
+	replayMu      sync.Mutex
+	replayStreams = map[string]*replayStream{}
+)
+
+// ReplayMiddleware numbers the events of the SSE responses of next and
+// replays those after Last-Event-ID to a GET carrying the header, like the
+// MCP Streamable HTTP transport allows. A resumed GET stream then goes on
+// receiving new events; a resumed POST stream ends after the replay.
+func ReplayMiddleware(next http.Handler) http.Handler {
+	if HTTPReplayWindow == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner := replayOwner(r)
+		if r.Method == http.MethodGet {
+			if id, stream, after, ok := lookupReplay(r.Header.Get("Last-Event-ID"), owner); ok {
+				resumeStream(w, r, next, id, stream, after)
+				return
+			}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &replayWriter{ResponseWriter: w, owner: owner, listen: r.Method == http.MethodGet}
+		defer rw.finish()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// replayOwner identifies who may resume the streams of r. Like cursorOwner it
+// includes the identity, as clients choose session IDs without --http-stateful.
+func replayOwner(r *http.Request) string {
+	owner := r.Header.Get(server.HeaderKeySessionID)
+	if identity := IdentityFromContext(r.Context()); identity != nil {
+		owner += "/" + identity.Method + ":" + identity.Name
+	}
+	return owner
+}
+
+// lookupReplay returns the stream and sequence number an event ID of owner
+// names, or false when it names none
+func lookupReplay(eventID, owner string) (string, *replayStream, int, bool) {
+	id, seq, ok := strings.Cut(eventID, "-")
+	if !ok {
+		return "", nil, 0, false
+	}
+	after, err := strconv.Atoi(seq)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	replayMu.Lock()
+	stream := replayStreams[id]
+	replayMu.Unlock()
+	if stream == nil || stream.owner != owner {
+		return "", nil, 0, false
+	}
+	return id, stream, after, true
+}
+
+// resumeStream waits for the response that wrote stream to end, sends its
+// events after the sequence number after and, for a GET stream not resumed
+// by someone else yet, goes on with new events on the same stream
+func resumeStream(w http.ResponseWriter, r *http.Request, next http.Handler, id string, stream *replayStream, after int) {
+	stream.mu.Lock()
+	done := stream.done
+	stream.mu.Unlock()
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	stream.mu.Lock()
+	for _, event := range stream.events {
+		if event.seq > after {
+			fmt.Fprintf(w, "id: %s-%d\n%s", id, event.seq, event.data)
+		}
+	}
+	resume := stream.listen && !stream.live
+	if resume {
+		stream.start()
+	}
+	stream.mu.Unlock()
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if !resume {
+		return
+	}
+
+	// The expiry timer may have fired while the stream was replayed
+	replayMu.Lock()
+	replayStreams[id] = stream
+	replayMu.Unlock()
+	rw := &replayWriter{ResponseWriter: w, id: id, stream: stream, wroteHeader: true}
+	defer rw.finish()
+	next.ServeHTTP(rw, r)
+}
+
+// start marks the stream live; the caller holds s.mu
+func (s *replayStream) start() {
+	s.live = true
+	s.done = make(chan struct{})
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// add keeps an event and returns its sequence number
+func (s *replayStream) add(data []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.events = append(s.events, replayEvent{seq: s.next, data: data})
+	if len(s.events) > maxReplayEvents {
+		s.events = s.events[len(s.events)-maxReplayEvents:]
+	}
+	return s.next
+}
+
+// end marks the stream dropped and forgets it after HTTPReplayWindow
+func (s *replayStream) end(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live = false
+	close(s.done)
+	s.timer = time.AfterFunc(HTTPReplayWindow, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.live {
+			return
+		}
+		replayMu.Lock()
+		delete(replayStreams, id)
+		replayMu.Unlock()
+	})
+}
+
+// replayWriter adds an ID to every event of an SSE response and keeps the
+// events for replay. Other responses pass through unchanged.
+type replayWriter struct {
+	http.ResponseWriter
+	owner       string
+	listen      bool
+	id          string
+	stream      *replayStream // Nil unless the response is a recorded SSE stream
+	wroteHeader bool
+	buf         []byte
+}
+
+func (rw *replayWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	if code == http.StatusOK && strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream") {
+		rw.id, rw.stream = newReplayStream(rw.owner, rw.listen)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends every complete event in p with its ID and buffers the rest.
+// Events are kept even when the client is gone, so they can be replayed.
+func (rw *replayWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.stream == nil {
+		return rw.ResponseWriter.Write(p)
+	}
+
+	rw.buf = append(rw.buf, p...)
+	var err error
+	for {
+		end := bytes.Index(rw.buf, []byte("\n\n"))
+		if end < 0 {
+			break
+		}
+		data := append([]byte(nil), rw.buf[:end+2]...)
+		rw.buf = rw.buf[end+2:]
+		seq := rw.stream.add(data)
+		if err == nil {
+			_, err = fmt.Fprintf(rw.ResponseWriter, "id: %s-%d\n%s", rw.id, seq, data)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *replayWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *replayWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *replayWriter) finish() {
+	if rw.stream != nil {
+		rw.stream.end(rw.id)
+	}
+}
+
+// newReplayStream registers a live stream of owner, or returns a nil stream
+// when maxReplayStreams are kept already
+func newReplayStream(owner string, listen bool) (string, *replayStream) {
+	id, err := newCursorToken()
+	if err != nil {
+		return "", nil
+	}
+	stream := &replayStream{owner: owner, listen: listen, live: true, done: make(chan struct{})}
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	if len(replayStreams) >= maxReplayStreams {
+		return "", nil
+	}
+	replayStreams[id] = stream
+	return id, stream
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// withReplay enables replay with no streams kept for the duration of a test
+func withReplay(t *testing.T) {
+	t.Helper()
+	replayMu.Lock()
+	oldStreams, oldWindow := replayStreams, HTTPReplayWindow
+	replayStreams, HTTPReplayWindow = map[string]*replayStream{}, time.Minute
+	replayMu.Unlock()
+	t.Cleanup(func() {
+		replayMu.Lock()
+		replayStreams, HTTPReplayWindow = oldStreams, oldWindow
+		replayMu.Unlock()
+	})
+}
+
+// sseHandler answers POSTs with the events of their body, or with JSON when
+// it is empty, and GETs with a single listen event, as if the stream dropped
+func sseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []string
+		if r.Method == http.MethodGet {
+			events = []string{"listen"}
+		} else if r.ContentLength > 0 {
+			body := make([]byte, r.ContentLength)
+			r.Body.Read(body)
+			events = strings.Split(string(body), ",")
+		}
+		if len(events) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range events {
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", event)
+		}
+	})
+}
+
+func serveReplay(t *testing.T, method, session, lastEventID, body string) string {
+	t.Helper()
+	r := httptest.NewRequest(method, "/mcp", strings.NewReader(body))
+	if session != "" {
+		r.Header.Set(server.HeaderKeySessionID, session)
+	}
+	if lastEventID != "" {
+		r.Header.Set("Last-Event-ID", lastEventID)
+	}
+	w := httptest.NewRecorder()
+	ReplayMiddleware(sseHandler()).ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+var eventIDPattern = regexp.MustCompile(`id: ([0-9a-f]+-\d+)\nevent: message\ndata: (\w+)\n\n`)
+
+// eventIDs returns the IDs of the events in body by their data
+func eventIDs(body string) map[string]string {
+	ids := map[string]string{}
+	for _, match := range eventIDPattern.FindAllStringSubmatch(body, -1) {
+		ids[match[2]] = match[1]
+	}
+	return ids
+}
+
+func TestReplayMiddleware(t *testing.T) {
+	withReplay(t)
+
+	if body := serveReplay(t, http.MethodPost, "s1", "", ""); strings.Contains(body, "id:") {
+		t.Errorf("JSON response was changed: %q", body)
+	}
+
+	ids := eventIDs(serveReplay(t, http.MethodPost, "s1", "", "progress,result"))
+	if len(ids) != 2 || !strings.HasSuffix(ids["progress"], "-1") || !strings.HasSuffix(ids["result"], "-2") {
+		t.Fatalf("events were not numbered: %v", ids)
+	}
+
+	tests := []struct {
+		name, session, lastEventID string
+		want                       []string
+	}{
+		{"replays later events", "s1", ids["progress"], []string{"result"}},
+		{"replays nothing after the last event", "s1", ids["result"], nil},
+		{"other session opens a new stream", "s2", ids["progress"], []string{"listen"}},
+		{"unknown stream opens a new stream", "s1", "0123-1", []string{"listen"}},
+		{"malformed ID opens a new stream", "s1", "garbage", []string{"listen"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventIDs(serveReplay(t, http.MethodGet, tt.session, tt.lastEventID, ""))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got events %v, want %v", got, tt.want)
+			}
+			for _, data := range tt.want {
+				if _, ok := got[data]; !ok {
+					t.Errorf("got events %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayMiddlewareResumesListenStreams(t *testing.T) {
+	withReplay(t)
+
+	first := eventIDs(serveReplay(t, http.MethodGet, "s1", "", ""))["listen"]
+	if first == "" {
+		t.Fatal("listen event was not numbered")
+	}
+	stream, _, _ := strings.Cut(first, "-")
+
+	// The resumed stream replays nothing new and goes on with the next event
+	next := eventIDs(serveReplay(t, http.MethodGet, "s1", first, ""))["listen"]
+	if next != stream+"-2" {
+		t.Errorf("resumed stream sent event %q, want %s-2", next, stream)
+	}
+	body := serveReplay(t, http.MethodGet, "s1", first, "")
+	if !strings.Contains(body, "id: "+stream+"-2\n") || !strings.Contains(body, "id: "+stream+"-3\n") {
+		t.Errorf("second resumption sent %q, want the replay of %s-2 and a new event", body, stream)
+	}
+}
+
+func TestReplayMiddlewareDisabled(t *testing.T) {
+	withReplay(t)
+	HTTPReplayWindow = 0
+
+	if body := serveReplay(t, http.MethodPost, "s1", "", "progress"); strings.Contains(body, "id:") {
+		t.Errorf("events were numbered with replay disabled: %q", body)
+	}
+}
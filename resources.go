@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ResourceScheme prefixes the URIs of schema resources:
+// postgres://{connection}/ lists schemas and tables,
+// postgres://{connection}/{schema}/{table} describes one table
+const ResourceScheme = "postgres://"
+
+// ResourceRefreshInterval is how often the catalogs are polled for changes
+var ResourceRefreshInterval time.Duration
+
+// catalogRetryInterval is how often a catalog that failed to load is retried
+// when polling is disabled
+const catalogRetryInterval = 10 * time.Second
+
+// catalogTable is one relation in a catalog snapshot. Fingerprint changes
+// whenever the columns or indexes of the relation change.
+type catalogTable struct {
+	Schema      string
+	Name        string
+	Kind        string
+	Fingerprint string
+}
+
+var (
+	catalogMu sync.Mutex
+	catalogs  = map[string][]catalogTable{} // Last snapshot per connection
+)
+
+// relationKinds names the pg_class relkinds published as resources
+var relationKinds = map[string]string{
+	"r": "table",
+	"p": "partitioned table",
+	"v": "view",
+	"m": "materialized view",
+	"f": "foreign table",
+}
+
+const catalogQuery = `
+	SELECT n.nspname AS schema, c.relname AS name, c.relkind::text AS kind,
+		md5(COALESCE((SELECT string_agg(a.attname || ' ' || format_type(a.atttypid, a.atttypmod) || ' ' || a.attnotnull, ',' ORDER BY a.attnum)
+			FROM pg_attribute a WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped), '')
+			|| COALESCE((SELECT string_agg(pg_get_indexdef(i.indexrelid), ',' ORDER BY i.indexrelid)
+			FROM pg_index i WHERE i.indrelid = c.oid), '')) AS fingerprint
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+		AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		AND n.nspname NOT LIKE 'pg_toast%'
+	ORDER BY n.nspname, c.relname`
+
+// RegisterResources publishes the schema of every connection as resources
+// and starts watching the catalogs for changes
+func RegisterResources(s *server.MCPServer) {
+	s.AddResourceTemplate(
+		mcp.NewResourceTemplate(ResourceScheme+"{connection}/{schema}/{table}", "Table definition",
+			mcp.WithTemplateDescription("DDL, columns, indexes and estimated row count of a table or view"),
+			mcp.WithTemplateMIMEType("application/json"),
+		),
+		ReadTableResource,
+	)
+	s.SetResources(catalogResources()...)
+
+	for _, name := range ConnectionNames() {
+		go WatchCatalog(s, name)
+	}
+}
+
+// catalogResources returns the listing resource of every connection and a
+// resource for every readable table of the last snapshots
+func catalogResources() []server.ServerResource {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	var resources []server.ServerResource
+	for _, name := range ConnectionNames() {
+		resources = append(resources, server.ServerResource{
+			Resource: mcp.NewResource(catalogURI(name), name+" schema",
+				mcp.WithResourceDescription(fmt.Sprintf("Schemas and tables of connection %s", name)),
+				mcp.WithMIMEType("application/json"),
+			),
+			Handler: ReadCatalogResource,
+		})
+		for _, t := range catalogs[name] {
+			if !TableAllowed(AccessRead, t.Schema, t.Name) {
+				continue
+			}
+			resources = append(resources, server.ServerResource{
+				Resource: mcp.NewResource(tableURI(name, t.Schema, t.Name), t.Schema+"."+t.Name,
+					mcp.WithResourceDescription(fmt.Sprintf("Definition of %s %s.%s on connection %s", relationKinds[t.Kind], t.Schema, t.Name, name)),
+					mcp.WithMIMEType("application/json"),
+				),
+				Handler: ReadTableResource,
+			})
+		}
+	}
+	return resources
+}
+
+// WatchCatalog loads the catalog of connection name and, unless
+// ResourceRefreshInterval is 0, polls it for changes. A catalog that fails to
+// load is retried even when polling is disabled. Every change is announced
+// with notifications/resources/list_changed.
+func WatchCatalog(s *server.MCPServer, name string) {
+	failing := false
+	for {
+		tables, err := loadCatalog(context.Background(), name)
+		switch {
+		case err != nil && !failing:
+			log.Printf("Failed to load the catalog of connection %s: %v", name, err)
+			failing = true
+		case err == nil:
+			if failing {
+				log.Printf("Loaded the catalog of connection %s", name)
+				failing = false
+			}
+			updateCatalog(s, name, tables)
+		}
+
+		switch {
+		case ResourceRefreshInterval > 0:
+			time.Sleep(ResourceRefreshInterval)
+		case failing:
+			time.Sleep(catalogRetryInterval)
+		default:
+			return
+		}
+	}
+}
+
+func loadCatalog(ctx context.Context, name string) ([]catalogTable, error) {
+	ctx, cancel := resourceContext(ctx, name)
+	defer cancel()
+
+	rows, _, err := DoQuery(ctx, catalogQuery, StatementTypeNoExplainCheck)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]catalogTable, len(rows))
+	for i, row := range rows {
+		tables[i] = catalogTable{
+			Schema:      row["schema"].(string),
+			Name:        row["name"].(string),
+			Kind:        row["kind"].(string),
+			Fingerprint: row["fingerprint"].(string),
+		}
+	}
+	return tables, nil
+}
+
+// updateCatalog stores a new snapshot and notifies clients of the differences.
+// Added or dropped tables change the resource list; altered tables and the
+// listing of the connection are announced to the sessions subscribed to them.
+func updateCatalog(s *server.MCPServer, name string, tables []catalogTable) {
+	catalogMu.Lock()
+	previous, loaded := catalogs[name]
+	catalogs[name] = tables
+	catalogMu.Unlock()
+
+	before := make(map[string]string, len(previous))
+	for _, t := range previous {
+		before[t.Schema+"."+t.Name] = t.Fingerprint
+	}
+
+	listChanged := !loaded || len(previous) != len(tables)
+	var updated []string
+	for _, t := range tables {
+		fingerprint, ok := before[t.Schema+"."+t.Name]
+		switch {
+		case !ok:
+			listChanged = true
+		case fingerprint != t.Fingerprint && TableAllowed(AccessRead, t.Schema, t.Name):
+			updated = append(updated, tableURI(name, t.Schema, t.Name))
+		}
+	}
+
+	if listChanged {
+		// Sends notifications/resources/list_changed
+		s.SetResources(catalogResources()...)
+	}
+	if loaded && (listChanged || len(updated) > 0) {
+		updated = append(updated, catalogURI(name))
+	}
+	NotifyResourceUpdated(s, updated...)
+}
+
+// ReadCatalogResource lists the schemas and tables of a connection
+func ReadCatalogResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	name, _, _, err := parseResourceURI(request.Params.URI)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := loadCatalog(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	type tableEntry struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+		URI  string `json:"uri"`
+	}
+	type schemaEntry struct {
+		Name   string       `json:"name"`
+		Tables []tableEntry `json:"tables"`
+	}
+	var schemas []*schemaEntry
+	for _, t := range tables {
+		if !TableAllowed(AccessRead, t.Schema, t.Name) {
+			continue
+		}
+		if len(schemas) == 0 || schemas[len(schemas)-1].Name != t.Schema {
+			schemas = append(schemas, &schemaEntry{Name: t.Schema})
+		}
+		schema := schemas[len(schemas)-1]
+		schema.Tables = append(schema.Tables, tableEntry{Name: t.Name, Kind: relationKinds[t.Kind], URI: tableURI(name, t.Schema, t.Name)})
+	}
+
+	return jsonResource(request.Params.URI, map[string]interface{}{
+		"connection": name,
+		"database":   connections[name].config.Database,
+		"schemas":    schemas,
+	})
+}
+
+// ReadTableResource describes a table: its DDL, columns, indexes and row estimate
+func ReadTableResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	name, schema, table, err := parseResourceURI(request.Params.URI)
+	if err != nil {
+		return nil, err
+	}
+	if schema == "" || table == "" {
+		return nil, Errorf("err_resource_not_table", "resource %s does not name a table", request.Params.URI)
+	}
+
+	ctx, cancel := resourceContext(ctx, name)
+	defer cancel()
+	description, err := DescribeTable(ctx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	description["connection"] = name
+	return jsonResource(request.Params.URI, description)
+}
+
+// DescribeTable reads the definition of a table or view on the connection of
+// ctx: its kind, DDL, columns, constraints, indexes and row estimate
+func DescribeTable(ctx context.Context, schema, table string) (map[string]interface{}, error) {
+	if err := CheckTableAccess(AccessRead, schema, table); err != nil {
+		return nil, err
+	}
+	relation := QuoteIdentifier(schema, table)
+
+	info, _, err := DoQuery(ctx, `
+		SELECT c.relkind::text AS kind,
+			CASE WHEN c.reltuples < 0 THEN NULL ELSE c.reltuples::bigint END AS row_estimate,
+			CASE WHEN c.relkind IN ('v', 'm') THEN pg_get_viewdef(c.oid, true) END AS view_definition
+		FROM pg_class c WHERE c.oid = to_regclass($1)`, StatementTypeNoExplainCheck, relation)
+	if err != nil {
+		return nil, err
+	}
+	if len(info) == 0 {
+		return nil, Errorf("err_table_missing", "table %s.%s not found", schema, table)
+	}
+	columns, _, err := DoQuery(ctx, `
+		SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type,
+			NOT a.attnotnull AS nullable, pg_get_expr(d.adbin, d.adrelid) AS default
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, StatementTypeNoExplainCheck, relation)
+	if err != nil {
+		return nil, err
+	}
+	constraints, _, err := DoQuery(ctx, `
+		SELECT conname AS name, pg_get_constraintdef(oid, true) AS definition
+		FROM pg_constraint WHERE conrelid = $1::regclass
+		ORDER BY contype, conname`, StatementTypeNoExplainCheck, relation)
+	if err != nil {
+		return nil, err
+	}
+	indexes, _, err := DoQuery(ctx, `
+		SELECT i.relname AS name, pg_get_indexdef(x.indexrelid) AS definition,
+			x.indisunique AS unique, x.indisprimary AS primary,
+			EXISTS (SELECT 1 FROM pg_constraint k WHERE k.conindid = x.indexrelid AND k.conrelid = x.indrelid) AS constraint
+		FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+		WHERE x.indrelid = $1::regclass
+		ORDER BY i.relname`, StatementTypeNoExplainCheck, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, _ := info[0]["kind"].(string)
+	return map[string]interface{}{
+		"schema":       schema,
+		"table":        table,
+		"kind":         relationKinds[kind],
+		"row_estimate": info[0]["row_estimate"],
+		"columns":      columns,
+		"constraints":  constraints,
+		"indexes":      indexes,
+		"ddl":          tableDDL(relation, kind, info[0], columns, constraints, indexes),
+	}, nil
+}
+
+// tableDDL reconstructs the CREATE statements of a relation from the catalog
+func tableDDL(relation, kind string, info map[string]interface{}, columns, constraints, indexes []map[string]interface{}) string {
+	var b strings.Builder
+	switch kind {
+	case "v", "m":
+		keyword := "VIEW"
+		if kind == "m" {
+			keyword = "MATERIALIZED VIEW"
+		}
+		definition, _ := info["view_definition"].(string)
+		fmt.Fprintf(&b, "CREATE %s %s AS\n%s;\n", keyword, relation, strings.TrimRight(definition, ";\n"))
+	default:
+		keyword := "TABLE"
+		if kind == "f" {
+			keyword = "FOREIGN TABLE"
+		}
+		var lines []string
+		for _, col := range columns {
+			line := fmt.Sprintf("    %s %s", QuoteIdentifier(col["name"].(string)), col["type"])
+			if nullable, _ := col["nullable"].(bool); !nullable {
+				line += " NOT NULL"
+			}
+			if def, ok := col["default"].(string); ok {
+				line += " DEFAULT " + def
+			}
+			lines = append(lines, line)
+		}
+		for _, con := range constraints {
+			lines = append(lines, fmt.Sprintf("    CONSTRAINT %s %s", QuoteIdentifier(con["name"].(string)), con["definition"]))
+		}
+		fmt.Fprintf(&b, "CREATE %s %s (\n%s\n);\n", keyword, relation, strings.Join(lines, ",\n"))
+	}
+
+	// Indexes backing constraints are created by the constraints above
+	for _, idx := range indexes {
+		if backing, _ := idx["constraint"].(bool); !backing {
+			fmt.Fprintf(&b, "%s;\n", idx["definition"])
+		}
+	}
+	return b.String()
+}
+
+// resourceContext selects connection name and applies --query-timeout
+func resourceContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, connectionKey{}, name)
+	if QueryTimeout > 0 {
+		return context.WithTimeout(ctx, QueryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// parseResourceURI splits postgres://connection/schema/table
+func parseResourceURI(uri string) (connection, schema, table string, err error) {
+	rest, ok := strings.CutPrefix(uri, ResourceScheme)
+	if !ok {
+		return "", "", "", Errorf("err_resource_uri", "unsupported resource URI %s", uri)
+	}
+	parts := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	for i, part := range parts {
+		if parts[i], err = url.PathUnescape(part); err != nil {
+			return "", "", "", Errorf("err_resource_uri_invalid", "invalid resource URI %s: %v", uri, err)
+		}
+	}
+	if _, ok := connections[parts[0]]; !ok {
+		return "", "", "", Errorf("err_unknown_connection", "unknown connection %q (available: %s)", parts[0], strings.Join(ConnectionNames(), ", "))
+	}
+
+	switch len(parts) {
+	case 1:
+		return parts[0], "", "", nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	}
+	return "", "", "", Errorf("err_resource_uri", "unsupported resource URI %s", uri)
+}
+
+func catalogURI(connection string) string {
+	return ResourceScheme + url.PathEscape(connection) + "/"
+}
+
+func tableURI(connection, schema, table string) string {
+	return ResourceScheme + url.PathEscape(connection) + "/" + url.PathEscape(schema) + "/" + url.PathEscape(table)
+}
+
+// jsonResource returns v as the JSON text contents of uri
+func jsonResource(uri string, v interface{}) ([]mcp.ResourceContents, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: "application/json", Text: string(data)}}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ColumnInfo is the structured description of a result column
+type ColumnInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// QueryResult is the structured content attached to every row returning tool result
+type QueryResult struct {
+	Columns         []ColumnInfo    `json:"columns"`
+	Rows            [][]interface{} `json:"rows"`
+	RowCount        int             `json:"row_count"`
+	Truncated       bool            `json:"truncated"`
+	Cursor          string          `json:"cursor,omitempty"`
+	ExecutionTimeMs float64         `json:"execution_time_ms"`
+}
+
+// ExecResult is the structured content attached to write tool results
+type ExecResult struct {
+	RowsAffected    int64   `json:"rows_affected"`
+	ExecutionTimeMs float64 `json:"execution_time_ms"`
+}
+
+// NewQueryResult converts rows into the structured result, keeping values in column order
+func NewQueryResult(m []map[string]interface{}, columns []Column, elapsed time.Duration) *QueryResult {
+	result := &QueryResult{
+		Columns:         make([]ColumnInfo, len(columns)),
+		Rows:            make([][]interface{}, len(m)),
+		RowCount:        len(m),
+		ExecutionTimeMs: durationMs(elapsed),
+	}
+
+	for i, col := range columns {
+		result.Columns[i] = ColumnInfo{Name: col.Name, Type: col.Type, Nullable: col.Nullable}
+	}
+
+	for r, item := range m {
+		row := make([]interface{}, len(columns))
+		for i, col := range columns {
+			row[i] = jsonValue(item[col.Name])
+		}
+		result.Rows[r] = row
+	}
+
+	return result
+}
+
+// QueryToolResult renders rows in format as text content and attaches them as
+// structured content. A non-empty cursor marks the result as truncated.
+func QueryToolResult(m []map[string]interface{}, columns []Column, format string, elapsed time.Duration, cursor string) (*mcp.CallToolResult, error) {
+	text, err := FormatResult(m, columns, format)
+	if err != nil {
+		return nil, err
+	}
+
+	structured := NewQueryResult(m, columns, elapsed)
+	result := mcp.NewToolResultStructured(structured, text)
+	if cursor != "" {
+		structured.Truncated = true
+		structured.Cursor = cursor
+		// A separate content block keeps the data itself parseable
+		note := Tf("result_truncated", "Result truncated after %d rows. Call read_query with cursor %q to fetch the next page.", len(m), cursor)
+		result.Content = append(result.Content, mcp.NewTextContent(note))
+	}
+	return result, nil
+}
+
+// ExecToolResult reports the number of affected rows as text and structured content
+func ExecToolResult(rowsAffected int64, elapsed time.Duration) *mcp.CallToolResult {
+	return mcp.NewToolResultStructured(
+		&ExecResult{RowsAffected: rowsAffected, ExecutionTimeMs: durationMs(elapsed)},
+		Tf("rows_affected", "%d rows affected", rowsAffected),
+	)
+}
+
+// ErrorResult reports err as a tool error. PostgreSQL errors additionally
+// carry their fields as structured content.
+func ErrorResult(err error) *mcp.CallToolResult {
+	result := mcp.NewToolResultError(Redact(err.Error()))
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		result.StructuredContent = qerr.Info()
+	}
+	return result
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
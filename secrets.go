@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactedText replaces passwords in logs and error messages
+const RedactedText = "xxxxx"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// Passwords in database URLs that were never parsed successfully
+	urlPasswordPattern = regexp.MustCompile(`(://[^:/?#@\s]*:)[^@\s]*@`)
+)
+
+// AddSecret registers a password to be redacted from logs and errors
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact removes registered passwords and DSN passwords from text
+func Redact(text string) string {
+	text = urlPasswordPattern.ReplaceAllString(text, "${1}"+RedactedText+"@")
+
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, RedactedText)
+	}
+	return text
+}
+
+// redactingWriter redacts every log line before it is written
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewRedactingWriter wraps w so nothing written through it leaks a password
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+// ReadSecretFile reads a DSN or password from a file such as a Docker or
+// Kubernetes secret, dropping the trailing newline
+func ReadSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// UseSystemServiceFile points PGSERVICEFILE at the system-wide
+// pg_service.conf in PGSYSCONFDIR, as libpq does, when no per-user
+// ~/.pg_service.conf exists
+func UseSystemServiceFile() {
+	if os.Getenv("PGSERVICEFILE") != "" {
+		return
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if _, err := os.Stat(filepath.Join(home, ".pg_service.conf")); err == nil {
+			return
+		}
+	}
+
+	dir := os.Getenv("PGSYSCONFDIR")
+	if dir == "" {
+		dir = "/etc/postgresql-common"
+	}
+	path := filepath.Join(dir, "pg_service.conf")
+	if _, err := os.Stat(path); err == nil {
+		os.Setenv("PGSERVICEFILE", path)
+	}
+}
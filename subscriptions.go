@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Resource subscription methods, which mcp-go answers with "method not found"
+// unless they are handled before reaching it
+const (
+	methodSubscribe   = "resources/subscribe"
+	methodUnsubscribe = "resources/unsubscribe"
+)
+
+// maxSubscriptions bounds the subscriptions of all sessions together
+const maxSubscriptions = 10000
+
+// subscriptions maps session IDs to the canonical URIs of the resources they
+// subscribed to, each with the URI as the client sent it
+var (
+	subscriptionsMu   sync.Mutex
+	subscriptions     = map[string]map[string]string{}
+	subscriptionCount int
+)
+
+// HandleSubscription answers message if it is a resources/subscribe or
+// resources/unsubscribe request of session, and returns nil for every other
+// message
+func HandleSubscription(session string, message []byte) mcp.JSONRPCMessage {
+	var request struct {
+		ID     *mcp.RequestId `json:"id"`
+		Method string         `json:"method"`
+		Params struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(message, &request) != nil || request.ID == nil {
+		return nil
+	}
+
+	var err error
+	switch request.Method {
+	case methodSubscribe:
+		err = subscribe(session, request.Params.URI)
+	case methodUnsubscribe:
+		err = unsubscribe(session, request.Params.URI)
+	default:
+		return nil
+	}
+	if err != nil {
+		return mcp.NewJSONRPCError(*request.ID, mcp.INVALID_PARAMS, err.Error(), nil)
+	}
+	return mcp.NewJSONRPCResultResponse(*request.ID, mcp.EmptyResult{})
+}
+
+// canonicalResourceURI returns the URI of the resource uri names, spelled the
+// way updateCatalog announces it. Tables hidden by the read ACL are refused.
+func canonicalResourceURI(uri string) (string, error) {
+	connection, schema, table, err := parseResourceURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if table == "" {
+		return catalogURI(connection), nil
+	}
+	if err := CheckTableAccess(AccessRead, schema, table); err != nil {
+		return "", err
+	}
+	return tableURI(connection, schema, table), nil
+}
+
+func subscribe(session, uri string) error {
+	canonical, err := canonicalResourceURI(uri)
+	if err != nil {
+		return err
+	}
+
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	uris := subscriptions[session]
+	if _, ok := uris[canonical]; ok {
+		return nil
+	}
+	if subscriptionCount >= maxSubscriptions {
+		return Errorf("err_too_many_subscriptions", "too many resource subscriptions, unsubscribe from others first")
+	}
+	if uris == nil {
+		uris = map[string]string{}
+		subscriptions[session] = uris
+	}
+	uris[canonical] = uri
+	subscriptionCount++
+	return nil
+}
+
+func unsubscribe(session, uri string) error {
+	canonical, err := canonicalResourceURI(uri)
+	if err != nil {
+		return err
+	}
+
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	if _, ok := subscriptions[session][canonical]; ok {
+		delete(subscriptions[session], canonical)
+		subscriptionCount--
+	}
+	if len(subscriptions[session]) == 0 {
+		delete(subscriptions, session)
+	}
+	return nil
+}
+
+// ForgetSubscriptions drops the subscriptions of a session that ended
+func ForgetSubscriptions(ctx context.Context, session server.ClientSession) {
+	forgetSubscriptions(session.SessionID())
+}
+
+func forgetSubscriptions(session string) {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	subscriptionCount -= len(subscriptions[session])
+	delete(subscriptions, session)
+}
+
+// NotifyResourceUpdated sends notifications/resources/updated for each of
+// uris to the sessions subscribed to it
+func NotifyResourceUpdated(s *server.MCPServer, uris ...string) {
+	type notification struct{ session, uri string }
+	var notifications []notification
+	subscriptionsMu.Lock()
+	for session, subscribed := range subscriptions {
+		for _, uri := range uris {
+			if as, ok := subscribed[uri]; ok {
+				notifications = append(notifications, notification{session, as})
+			}
+		}
+	}
+	subscriptionsMu.Unlock()
+
+	for _, n := range notifications {
+		// Fails for sessions whose client is gone, which have nobody to tell
+		s.SendNotificationToSpecificClient(n.session, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": n.uri})
+	}
+}
+
+// SubscriptionMiddleware answers the subscription requests POSTed to next.
+// session returns the MCP session of a request, empty when it has none, and
+// reply sends a response the way the transport expects.
+func SubscriptionMiddleware(next http.Handler, session func(*http.Request) string, reply func(http.ResponseWriter, string, mcp.JSONRPCMessage)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := session(r)
+		if r.Method != http.MethodPost || id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if response := HandleSubscription(id, body); response != nil {
+			reply(w, id, response)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// subscriptionReader passes the lines of in on, except subscription requests,
+// which it answers on out. session delivers the ID of the stdio session once
+// the server has registered it.
+func subscriptionReader(in io.Reader, out io.Writer, session <-chan string) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		reader := bufio.NewReader(in)
+		id := ""
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				if id == "" {
+					id = <-session
+				}
+				if response := HandleSubscription(id, line); response != nil {
+					data, _ := json.Marshal(response)
+					out.Write(append(data, '\n'))
+				} else if _, err := pw.Write(line); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// lockedWriter serializes the messages the stdio server and
+// subscriptionReader write to stdout
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// withSubscriptions starts a test without subscriptions and with a main
+// connection for resource URIs to name
+func withSubscriptions(t *testing.T) {
+	t.Helper()
+	subscriptionsMu.Lock()
+	oldSubscriptions, oldCount, oldConnections := subscriptions, subscriptionCount, connections
+	subscriptions, subscriptionCount = map[string]map[string]string{}, 0
+	connections = map[string]*Connection{"main": {Name: "main"}}
+	subscriptionsMu.Unlock()
+	t.Cleanup(func() {
+		subscriptionsMu.Lock()
+		subscriptions, subscriptionCount, connections = oldSubscriptions, oldCount, oldConnections
+		subscriptionsMu.Unlock()
+	})
+}
+
+func subscriptionRequest(method, uri string) []byte {
+	return []byte(`{"jsonrpc":"2.0","id":7,"method":"` + method + `","params":{"uri":"` + uri + `"}}`)
+}
+
+func TestHandleSubscription(t *testing.T) {
+	withSubscriptions(t)
+	withACLs(t, ACL{Deny: []string{"billing*"}}, ACL{})
+
+	tests := []struct {
+		name    string
+		message []byte
+		handled bool
+		wantErr bool
+	}{
+		{"table", subscriptionRequest(methodSubscribe, "postgres://main/public/users"), true, false},
+		{"escaped table", subscriptionRequest(methodSubscribe, "postgres://main/public/%75sers"), true, false},
+		{"catalog", subscriptionRequest(methodSubscribe, "postgres://main/"), true, false},
+		{"hidden table", subscriptionRequest(methodSubscribe, "postgres://main/billing/invoices"), true, true},
+		{"unknown connection", subscriptionRequest(methodSubscribe, "postgres://other/public/users"), true, true},
+		{"other scheme", subscriptionRequest(methodSubscribe, "file:///etc/passwd"), true, true},
+		{"unsubscribe", subscriptionRequest(methodUnsubscribe, "postgres://main/"), true, false},
+		{"other method", subscriptionRequest("resources/read", "postgres://main/"), false, false},
+		{"notification", []byte(`{"jsonrpc":"2.0","method":"resources/subscribe","params":{"uri":"postgres://main/"}}`), false, false},
+		{"not JSON", []byte("resources/subscribe"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := HandleSubscription("s1", tt.message)
+			if (response != nil) != tt.handled {
+				t.Fatalf("HandleSubscription = %v, want handled %v", response, tt.handled)
+			}
+			if _, isErr := response.(mcp.JSONRPCError); isErr != tt.wantErr {
+				t.Errorf("HandleSubscription = %#v, want error %v", response, tt.wantErr)
+			}
+		})
+	}
+
+	// The escaped URI names the table subscribed to already
+	if got := subscriptions["s1"]; len(got) != 1 || got["postgres://main/public/users"] != "postgres://main/public/users" {
+		t.Errorf("subscriptions = %v, want only postgres://main/public/users", got)
+	}
+	if subscriptionCount != 1 {
+		t.Errorf("subscriptionCount = %d, want 1", subscriptionCount)
+	}
+}
+
+func TestSubscriptionLimit(t *testing.T) {
+	withSubscriptions(t)
+	subscriptionCount = maxSubscriptions
+
+	if _, isErr := HandleSubscription("s1", subscriptionRequest(methodSubscribe, "postgres://main/")).(mcp.JSONRPCError); !isErr {
+		t.Error("subscription beyond maxSubscriptions was accepted")
+	}
+	forgetSubscriptions("s1")
+	if subscriptionCount != maxSubscriptions {
+		t.Errorf("subscriptionCount = %d after forgetting a session without subscriptions", subscriptionCount)
+	}
+}
+
+// testSession is a ClientSession collecting its notifications
+type testSession struct {
+	id            string
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+func (s *testSession) SessionID() string { return s.id }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func TestNotifyResourceUpdated(t *testing.T) {
+	withSubscriptions(t)
+	s := server.NewMCPServer("test", "1.0.0", server.WithResourceCapabilities(true, true))
+	subscriber := &testSession{id: "s1", notifications: make(chan mcp.JSONRPCNotification, 4)}
+	other := &testSession{id: "s2", notifications: make(chan mcp.JSONRPCNotification, 4)}
+	for _, session := range []*testSession{subscriber, other} {
+		if err := s.RegisterSession(context.Background(), session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	HandleSubscription("s1", subscriptionRequest(methodSubscribe, "postgres://main/public/%75sers"))
+
+	NotifyResourceUpdated(s, "postgres://main/public/users", "postgres://main/public/orders")
+	if len(subscriber.notifications) != 1 || len(other.notifications) != 0 {
+		t.Fatalf("got %d and %d notifications, want 1 for the subscriber only", len(subscriber.notifications), len(other.notifications))
+	}
+	notification := <-subscriber.notifications
+	if notification.Method != mcp.MethodNotificationResourceUpdated || notification.Params.AdditionalFields["uri"] != "postgres://main/public/%75sers" {
+		t.Errorf("got notification %+v, want resources/updated of the subscribed URI", notification)
+	}
+
+	ForgetSubscriptions(context.Background(), subscriber)
+	NotifyResourceUpdated(s, "postgres://main/public/users")
+	if len(subscriber.notifications) != 0 {
+		t.Error("ended session was still notified")
+	}
+}
+
+func TestSubscriptionReader(t *testing.T) {
+	withSubscriptions(t)
+
+	input := string(subscriptionRequest(methodSubscribe, "postgres://main/")) + "\n" +
+		`{"jsonrpc":"2.0","id":8,"method":"ping"}` + "\n"
+	session := make(chan string, 1)
+	session <- "stdio"
+	var out bytes.Buffer
+	passed, err := io.ReadAll(subscriptionReader(strings.NewReader(input), &out, session))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(passed) != `{"jsonrpc":"2.0","id":8,"method":"ping"}`+"\n" {
+		t.Errorf("passed on %q, want only the ping", passed)
+	}
+	var response mcp.JSONRPCResponse
+	if err := json.Unmarshal(out.Bytes(), &response); err != nil || response.Result == nil {
+		t.Errorf("answered %q, want a result: %v", out.String(), err)
+	}
+	if _, ok := subscriptions["stdio"]["postgres://main/"]; !ok {
+		t.Error("stdio session was not subscribed")
+	}
+}
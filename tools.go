@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ToolSettings customizes a single tool, set from a [tools.<name>] config table
+type ToolSettings struct {
+	Enabled         *bool    // Overrides the enabled and disabled patterns when set
+	Description     string   // Replaces the built-in description
+	HiddenArguments []string // Optional arguments removed from the tool
+}
+
+// WriteTools are only offered without --read-only and to read-write callers
+var WriteTools = []string{"write_query", "update_query", "delete_query", "create_table", "alter_table", "create_index"}
+
+var (
+	// Glob patterns of tools to offer (all when empty) and never to offer
+	EnabledTools  []string
+	DisabledTools []string
+	ToolOverrides = map[string]*ToolSettings{}
+)
+
+// ToolEnabled reports whether the tool called name may be registered
+func ToolEnabled(name string) bool {
+	if settings, ok := ToolOverrides[name]; ok && settings.Enabled != nil {
+		return *settings.Enabled
+	}
+	for _, pattern := range DisabledTools {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(EnabledTools) == 0 {
+		return true
+	}
+	for _, pattern := range EnabledTools {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseToolSettings reads a [tools.<name>] config table
+func ParseToolSettings(name string, table map[string]interface{}) error {
+	settings := &ToolSettings{}
+	for key, value := range table {
+		var ok bool
+		switch key {
+		case "enabled":
+			var enabled bool
+			enabled, ok = value.(bool)
+			settings.Enabled = &enabled
+		case "description":
+			settings.Description, ok = value.(string)
+		case "hidden_arguments":
+			var items []interface{}
+			if items, ok = value.([]interface{}); ok {
+				for _, item := range items {
+					arg, isString := item.(string)
+					ok = ok && isString
+					settings.HiddenArguments = append(settings.HiddenArguments, arg)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown setting \"tools.%s.%s\"", name, key)
+		}
+		if !ok {
+			return fmt.Errorf("tools.%s.%s has the wrong type", name, key)
+		}
+	}
+	ToolOverrides[name] = settings
+	return nil
+}
+
+// CustomizeTool applies the configured description and hidden arguments to tool
+func CustomizeTool(tool mcp.Tool) (mcp.Tool, error) {
+	settings, ok := ToolOverrides[tool.Name]
+	if !ok {
+		return tool, nil
+	}
+
+	if settings.Description != "" {
+		tool.Description = settings.Description
+	}
+	for _, arg := range settings.HiddenArguments {
+		if _, ok := tool.InputSchema.Properties[arg]; !ok {
+			return tool, fmt.Errorf("tools.%s: %s has no argument %q", tool.Name, tool.Name, arg)
+		}
+		if slices.Contains(tool.InputSchema.Required, arg) {
+			return tool, fmt.Errorf("tools.%s: required argument %q cannot be hidden", tool.Name, arg)
+		}
+		delete(tool.InputSchema.Properties, arg)
+	}
+	return tool, nil
+}
+
+// HideArguments rejects calls that pass an argument hidden from the tool,
+// since clients may still send arguments the schema does not list
+func HideArguments(name string, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	settings, ok := ToolOverrides[name]
+	if !ok || len(settings.HiddenArguments) == 0 {
+		return next
+	}
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := request.GetArguments()
+		for _, arg := range settings.HiddenArguments {
+			if _, ok := args[arg]; ok {
+				return mcp.NewToolResultError(Tf("err_argument_hidden", "argument %q is not available", arg)), nil
+			}
+		}
+		return next(ctx, request)
+	}
+}
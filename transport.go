@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Transports
+const (
+	TransportStdio = "stdio"
+	TransportSSE   = "sse"
+	TransportHTTP  = "http" // MCP Streamable HTTP
+)
+
+var (
+	HTTPPath string // Endpoint of the Streamable HTTP transport
+	// HTTPStateful tracks sessions in memory and rejects unknown or terminated
+	// session IDs, which requires sticky sessions behind a load balancer
+	HTTPStateful bool
+	// HTTPHeartbeatInterval pings idle GET streams so proxies keep them open
+	HTTPHeartbeatInterval time.Duration
+
+	TLSCertFile string // PEM certificate (chain) served by the SSE and HTTP transports
+	TLSKeyFile  string // PEM private key of TLSCertFile
+	// TLSClientCAFile holds the PEM CA certificates client certificates must
+	// be signed by; clients without one are refused when set
+	TLSClientCAFile string
+)
+
+// Serve runs s on the selected transport until it fails
+func Serve(s *server.MCPServer) error {
+	if Transport == TransportStdio {
+		return serveStdio(s)
+	}
+
+	addr := net.JoinHostPort(IPaddress, strconv.Itoa(Port))
+	scheme := "http"
+	if TLSCertFile != "" {
+		scheme = "https"
+	}
+	var handler http.Handler
+	switch Transport {
+	case TransportSSE:
+		sse := server.NewSSEServer(s, server.WithBaseURL(scheme+"://"+addr))
+		handler = SubscriptionMiddleware(sse, func(r *http.Request) string {
+			return r.URL.Query().Get("sessionId")
+		}, func(w http.ResponseWriter, session string, response mcp.JSONRPCMessage) {
+			// SSE clients get their responses on the event stream
+			if err := sse.SendEventToSession(session, response); err != nil {
+				forgetSubscriptions(session)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		})
+	case TransportHTTP:
+		mux := http.NewServeMux()
+		streamable := server.NewStreamableHTTPServer(s,
+			server.WithEndpointPath(HTTPPath),
+			server.WithStateful(HTTPStateful),
+			server.WithHeartbeatInterval(HTTPHeartbeatInterval),
+		)
+		mux.Handle(HTTPPath, ReplayMiddleware(SubscriptionMiddleware(streamable, func(r *http.Request) string {
+			return r.Header.Get(server.HeaderKeySessionID)
+		}, func(w http.ResponseWriter, session string, response mcp.JSONRPCMessage) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})))
+		handler = mux
+	default:
+		return fmt.Errorf("unsupported transport %q", Transport)
+	}
+
+	if !AuthEnabled() && TLSClientCAFile == "" {
+		log.Printf("Warning: the %s transport accepts every client, see --auth-token and --auth-jwks", Transport)
+	}
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           AuthMiddleware(handler),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("%s server listening on %s://%s", strings.ToUpper(Transport), scheme, addr)
+	if TLSCertFile == "" {
+		return httpServer.ListenAndServe()
+	}
+
+	tlsConfig, err := TLSConfig()
+	if err != nil {
+		return err
+	}
+	httpServer.TLSConfig = tlsConfig
+	return httpServer.ListenAndServeTLS(TLSCertFile, TLSKeyFile)
+}
+
+// serveStdio is server.ServeStdio with subscription requests answered before
+// they reach s
+func serveStdio(s *server.MCPServer) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	session := make(chan string, 1)
+	stdio := server.NewStdioServer(s)
+	stdio.SetContextFunc(func(ctx context.Context) context.Context {
+		session <- sessionID(ctx)
+		return ctx
+	})
+	stdout := &lockedWriter{w: os.Stdout}
+	return stdio.Listen(ctx, subscriptionReader(os.Stdin, stdout, session), stdout)
+}
+
+// TLSConfig returns the TLS settings of the network transports, requiring
+// client certificates when a client CA is configured
+func TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if TLSClientCAFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", TLSClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}